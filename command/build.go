@@ -0,0 +1,134 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"github.com/joelferrier/marsho/repository"
+	"os"
+	"strings"
+)
+
+type BuildCommand struct {
+	Meta
+	HelpText string
+}
+
+type buildOpts struct {
+	outputOpts
+	Packager   string
+	Platform   string
+	SigningKey string
+	Dir        string
+}
+
+func (c *BuildCommand) setHelp() {
+	c.HelpText = `
+Usage: marsho repo build [options] [directory]
+    Generate repository metadata for a directory of LiME kernel modules
+
+    [options]
+    -packager string  packager recorded for each module
+                      Default: marsho
+    -platform string  platform recorded for each module
+                      Default: linux
+    -key string       armored secret key used to sign repomd.xml and every
+                      module, the passphrase is read from
+                      MARSHO_SIGNING_PASSPHRASE when the key is encrypted
+    -output string    output format table, json, yaml or csv
+                      Default: table
+
+    [directory]       repository directory, modules are read from
+                      [directory]/modules and metadata is written to
+                      [directory]/repodata
+`
+}
+
+func (c *BuildCommand) Run(args []string) int {
+	opts, err := buildArgs(args)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+
+	manifest, err := repository.Build(opts.Dir, repository.BuildOptions{
+		Packager:   opts.Packager,
+		Platform:   opts.Platform,
+		SigningKey: opts.SigningKey,
+		Passphrase: os.Getenv("MARSHO_SIGNING_PASSPHRASE"),
+	})
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	if opts.structured() {
+		err = opts.write(newModuleRecords(manifest.Modules))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		return 0
+	}
+
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	for _, mod := range manifest.Modules {
+		table.AddRow(fmt.Sprintf("kernel: %s", mod.Version), fmt.Sprintf("arch: %s", mod.Arch), fmt.Sprintf("path: %s", mod.Location.Href))
+	}
+
+	fmt.Println(table)
+	signed := "unsigned"
+	if opts.SigningKey != "" {
+		signed = "signed"
+	}
+	fmt.Printf("\nBuilt %s repository with %d LiME modules in %s\n", signed, len(manifest.Modules), opts.Dir)
+	return 0
+}
+
+func (c *BuildCommand) Help() string {
+	c.setHelp()
+	return strings.TrimSpace(c.HelpText)
+}
+
+func (c *BuildCommand) Synopsis() string {
+	return "Generate repository metadata for a directory of modules"
+}
+
+func buildArgs(args []string) (buildOpts, error) {
+	opts := buildOpts{}
+
+	buildCmd := flag.NewFlagSet("build", flag.ExitOnError)
+	packager := buildCmd.String("packager", "marsho", "Module packager")
+	platform := buildCmd.String("platform", "linux", "Module platform")
+	signingKey := buildCmd.String("key", "", "Armored secret signing key")
+	opts.outputOpts.register(buildCmd)
+
+	buildCmd.Parse(args)
+	log.Debug(fmt.Sprintf("parsed packager: %s", *packager))
+	log.Debug(fmt.Sprintf("parsed platform: %s", *platform))
+	log.Debug(fmt.Sprintf("parsed signingKey: %s", *signingKey))
+	opts.outputOpts.debug()
+
+	err := opts.validate("repo build")
+	if err != nil {
+		return opts, err
+	}
+
+	var dir string
+	if len(buildCmd.Args()) != 1 {
+		return opts, errors.New("repo build: missing directory argument")
+	} else {
+		dir = buildCmd.Args()[0]
+	}
+	log.Debug(fmt.Sprintf("parsed dir: %s", dir))
+
+	opts.Packager = *packager
+	opts.Platform = *platform
+	opts.SigningKey = *signingKey
+	opts.Dir = dir
+
+	return opts, nil
+}
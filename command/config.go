@@ -0,0 +1,110 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"github.com/joelferrier/marsho/config"
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+type ConfigCommand struct {
+	Meta
+}
+
+func (c *ConfigCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *ConfigCommand) Help() string {
+	helpText := `
+Usage: marsho config <subcommand> [options]
+    Inspect marsho configuration
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ConfigCommand) Synopsis() string {
+	return "Inspect marsho configuration"
+}
+
+type ConfigShowCommand struct {
+	Meta
+	HelpText string
+}
+
+func (c *ConfigShowCommand) setHelp() {
+	c.HelpText = `
+Usage: marsho config show [options]
+    Print the effective configuration and where each value came from
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -output string  output format table, json, yaml or csv
+                    Default: table
+
+    Values are merged from built in defaults, the configuration file and
+    MARSHO_* environment variables eg. MARSHO_HTTP_TIMEOUT=30s, lists are
+    comma separated in environment variables
+`
+}
+
+func (c *ConfigShowCommand) Run(args []string) int {
+	configCmd := flag.NewFlagSet("config show", flag.ExitOnError)
+	configPath := configCmd.String("config", "", "Configuration file")
+	output := outputOpts{}
+	output.register(configCmd)
+	configCmd.Parse(args)
+	log.Debug(fmt.Sprintf("parsed configPath: %s", *configPath))
+	output.debug()
+
+	if len(configCmd.Args()) != 0 {
+		fmt.Printf("config show: unexpected arguments %s\n\n%s\n",
+			strings.Join(configCmd.Args(), " "), c.Help())
+		return 1
+	}
+	err := output.validate("config show")
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+
+	path := config.Path(*configPath)
+	conf, err := config.Load(path)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	if output.structured() {
+		err = output.write(newSettingRecords(conf.Settings()))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		return 0
+	}
+
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	table.AddRow("KEY", "VALUE", "SOURCE")
+	for _, setting := range conf.Settings() {
+		table.AddRow(setting.Key, setting.Value, setting.Origin)
+	}
+
+	fmt.Println(table)
+	fmt.Printf("\nConfiguration file: %s\n", path)
+	return 0
+}
+
+func (c *ConfigShowCommand) Help() string {
+	c.setHelp()
+	return strings.TrimSpace(c.HelpText)
+}
+
+func (c *ConfigShowCommand) Synopsis() string {
+	return "Print the effective configuration"
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/gosuri/uitable"
 	"github.com/joelferrier/marsho/repository"
 	"strings"
 )
@@ -14,9 +15,13 @@ type FetchCommand struct {
 }
 
 type fetchOpts struct {
-	RepoUrl  string
-	NoVerify bool
-	KernVer  string
+	repoOpts
+	outputOpts
+	All     bool
+	First   bool
+	Latest  bool
+	Workers int
+	KernVer string
 }
 
 func (c *FetchCommand) setHelp() {
@@ -25,12 +30,30 @@ Usage: marsho fetch [options] [kernel-version]
     Fetch LiME kernel module
 
     [options]
-    -repo string   repository url
-                   Default: https://threatresponse-lime-modules.s3.amazonaws.com/
+    -config string configuration file
+                   Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string   repository url, file:// url or local directory
+                   Default: repositories from the configuration
+                   or https://threatresponse-lime-modules.s3.amazonaws.com/
+    -keyring string keyring file, armored, binary or GnuPG keybox
+                   Default: gnupg, $GNUPGHOME/pubring.kbx or pubring.gpg
     -gpg-no-verify disable GPG Verification
+    -refresh       ignore cached repository metadata
+    -offline       use cached repository metadata only
+    -replay        accept rolled back or stale repository metadata
+    -all           fetch every module matching kernel-version
+    -first         when several modules match, fetch the first one
+    -latest        when several modules match, fetch the highest version
+    -workers int   number of concurrent downloads when using -all
+                   Default: 4
+    -output string output format table, json, yaml or csv, structured
+                   formats report the path, checksum and verification
+                   Default: table
 
     [kernel-version]
     kernel module version, eg. 4.4.10-22.54.amzn1.x86_64
+    Globs are supported eg. '4.4.*amzn1*', when several modules match
+    and stdin is a terminal a numbered list is shown to choose from
 `
 }
 
@@ -38,21 +61,87 @@ func (c *FetchCommand) Run(args []string) int {
 	opts, err := fetchArgs(args)
 	if err != nil {
 		fmt.Printf("%s\n\n%s\n", err, c.Help())
-		return 0
+		return 1
 	}
-	repo := repository.DefaultRepository()
-	if opts.RepoUrl != "" {
-		// ensure repo url has a trailing slash
-		if opts.RepoUrl[len(opts.RepoUrl)-1:] != "/" {
-			opts.RepoUrl = opts.RepoUrl + "/"
+	conf, err := c.loadConfig(opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	repos, err := repositories(conf, opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	if opts.All {
+		return c.fetchAll(repos, opts)
+	}
+
+	var choose repository.Selector
+	switch {
+	case opts.First:
+		choose = repository.SelectFirst
+	case opts.Latest:
+		choose = repository.SelectLatest
+	case isInteractive() && !opts.structured():
+		choose = promptModule
+	}
+
+	result, err := repos.Get(opts.KernVer, choose)
+	if opts.structured() && result.Module.Name != "" {
+		werr := opts.write(newFetchRecords([]repository.FetchResult{result}))
+		if werr != nil {
+			log.Critical(werr)
+			return 1
 		}
-		repo.BaseUrl = opts.RepoUrl
 	}
-	repo.SkipGPGVerify = opts.NoVerify
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	log.Info(fmt.Sprintf("module downloaded to %s", result.Path))
+
+	return 0
+}
 
-	repo.Get(opts.KernVer)
+func (c *FetchCommand) fetchAll(repos *repository.Group, opts fetchOpts) int {
+	results, err := repos.GetAll(opts.KernVer, opts.Workers)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
 
-	return 1
+	if opts.structured() {
+		err = opts.write(newFetchRecords(results))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+	}
+
+	failed := 0
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			table.AddRow("failed", fmt.Sprintf("kernel: %s", result.Module.Version), result.Err)
+		} else {
+			table.AddRow("ok", fmt.Sprintf("kernel: %s", result.Module.Version), fmt.Sprintf("path: %s", result.Path))
+		}
+	}
+
+	if !opts.structured() {
+		fmt.Println(table)
+		fmt.Printf("\nFetched %d of %d LiME modules for '%s' from %s\n",
+			len(results)-failed, len(results), opts.KernVer, strings.Join(repos.Sources(), ", "))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
 }
 
 func (c *FetchCommand) Help() string {
@@ -68,12 +157,20 @@ func fetchArgs(args []string) (fetchOpts, error) {
 	opts := fetchOpts{}
 
 	fetchCmd := flag.NewFlagSet("fetch", flag.ExitOnError)
-	repoUrl := fetchCmd.String("repo", "", "LiME Repository url")
-	noVerify := fetchCmd.Bool("gpg-no-verify", false, "Disable GPG Verification")
+	opts.repoOpts.register(fetchCmd)
+	opts.outputOpts.register(fetchCmd)
+	all := fetchCmd.Bool("all", false, "Fetch all matching modules")
+	first := fetchCmd.Bool("first", false, "Fetch the first matching module")
+	latest := fetchCmd.Bool("latest", false, "Fetch the latest matching module")
+	workers := fetchCmd.Int("workers", 4, "Concurrent downloads")
 
 	fetchCmd.Parse(args)
-	log.Debug(fmt.Sprintf("parsed repoUrl: %s", *repoUrl))
-	log.Debug(fmt.Sprintf("parsed noVerify: %t", *noVerify))
+	opts.repoOpts.debug()
+	opts.outputOpts.debug()
+	log.Debug(fmt.Sprintf("parsed all: %t", *all))
+	log.Debug(fmt.Sprintf("parsed first: %t", *first))
+	log.Debug(fmt.Sprintf("parsed latest: %t", *latest))
+	log.Debug(fmt.Sprintf("parsed workers: %d", *workers))
 
 	var kernVer string
 	if len(fetchCmd.Args()) != 1 {
@@ -83,8 +180,28 @@ func fetchArgs(args []string) (fetchOpts, error) {
 	}
 	log.Debug(fmt.Sprintf("parsed kernVer: %s", kernVer))
 
-	opts.RepoUrl = *repoUrl
-	opts.NoVerify = *noVerify
+	selected := 0
+	for _, set := range []bool{*all, *first, *latest} {
+		if set {
+			selected++
+		}
+	}
+	if selected > 1 {
+		return opts, errors.New("fetch: -all, -first and -latest are mutually exclusive")
+	}
+
+	if *workers < 1 {
+		return opts, errors.New("fetch: -workers must be at least 1")
+	}
+	err := opts.validate("fetch")
+	if err != nil {
+		return opts, err
+	}
+
+	opts.All = *all
+	opts.First = *first
+	opts.Latest = *latest
+	opts.Workers = *workers
 	opts.KernVer = kernVer
 
 	return opts, nil
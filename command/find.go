@@ -4,8 +4,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/gosuri/uitable"
-	"github.com/joelferrier/marsho/repository"
 	"strings"
 )
 
@@ -15,9 +13,12 @@ type FindCommand struct {
 }
 
 type findOpts struct {
-	RepoUrl  string
-	NoVerify bool
-	KernVer  string
+	repoOpts
+	filterOpts
+	outputOpts
+	Regex   string
+	Range   string
+	KernVer string
 }
 
 func (c *FindCommand) setHelp() {
@@ -26,12 +27,32 @@ Usage: marsho find [options] [kernel-version]
     Search repository for LiME kernel modules
 
     [options]
-    -repo string      repository url
-                      Default: https://threatresponse-lime-modules.s3.amazonaws.com/
+    -config string    configuration file
+                      Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string      repository url, file:// url or local directory
+                      Default: repositories from the configuration
+                      or https://threatresponse-lime-modules.s3.amazonaws.com/
+    -keyring string   keyring file, armored, binary or GnuPG keybox
+                      Default: gnupg, $GNUPGHOME/pubring.kbx or pubring.gpg
     -gpg-no-verify    disable GPG Verification
+    -refresh          ignore cached repository metadata
+    -offline          use cached repository metadata only
+    -replay           accept rolled back or stale repository metadata
+    -arch string      only modules for this architecture eg. aarch64
+    -platform string  only modules for this platform eg. linux
+    -type string      only modules of this type eg. lime
+    -packager string  only modules built by this packager eg. lime-compiler
+                      Globs are supported by every filter
+    -regex string     only kernel versions matching this regular expression
+                      eg. '^4\.14\..*amzn2'
+    -range string     only kernel versions within this range
+                      eg. '>=4.14.100 <4.15', alternatives separated by ||
+    -output string    output format table, json, yaml or csv
+                      Default: table
 
     [kernel-version]  kernel module version eg. 4.4.10-22.54.amzn1.x86_64
                       Globs are supported eg. 4.4.10*amzn1.x86_64
+                      Optional when -regex or -range is given
 `
 }
 
@@ -39,34 +60,39 @@ func (c *FindCommand) Run(args []string) int {
 	opts, err := findArgs(args)
 	if err != nil {
 		fmt.Printf("%s\n\n%s\n", err, c.Help())
-		return 0
+		return 1
 	}
-	repo := repository.DefaultRepository()
-	if opts.RepoUrl != "" {
-		// ensure repo url has a trailing slash
-		if opts.RepoUrl[len(opts.RepoUrl)-1:] != "/" {
-			opts.RepoUrl = opts.RepoUrl + "/"
-		}
-		repo.BaseUrl = opts.RepoUrl
+	conf, err := c.loadConfig(opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
 	}
-	repo.SkipGPGVerify = opts.NoVerify
-
-	modules, err := repo.Find(opts.KernVer)
+	repos, err := repositories(conf, opts.repoOpts)
 	if err != nil {
 		log.Critical(err)
-		return 0
+		return 1
 	}
 
-	table := uitable.New()
-	table.MaxColWidth = 80
-	table.Wrap = true
-	for _, mod := range modules {
-		table.AddRow(fmt.Sprintf("kernel: %s", mod.Version), fmt.Sprintf("path: /modules/%s", mod.Name))
+	filter := opts.filter(opts.KernVer)
+	filter.Regex = opts.Regex
+	filter.Range = opts.Range
+	modules, err := repos.Find(filter)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	if opts.structured() {
+		err = opts.write(newModuleRecords(modules))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		return 0
 	}
 
-	fmt.Println(table)
-	fmt.Printf("\nMatched %d LiME modules for '%s' in %s\n", len(modules), opts.KernVer, repo.BaseUrl)
-	return 1
+	fmt.Println(moduleTable(modules, len(repos.Repositories) > 1))
+	fmt.Printf("\nMatched %d LiME modules for %s in %s\n", len(modules), filter, strings.Join(repos.Sources(), ", "))
+	return 0
 }
 
 func (c *FindCommand) Help() string {
@@ -82,23 +108,29 @@ func findArgs(args []string) (findOpts, error) {
 	opts := findOpts{}
 
 	findCmd := flag.NewFlagSet("find", flag.ExitOnError)
-	repoUrl := findCmd.String("repo", "", "LiME Repository url")
-	noVerify := findCmd.Bool("gpg-no-verify", false, "Disable GPG Verification")
+	opts.repoOpts.register(findCmd)
+	opts.filterOpts.register(findCmd)
+	findCmd.StringVar(&opts.Regex, "regex", "", "Kernel version regular expression")
+	findCmd.StringVar(&opts.Range, "range", "", "Kernel version range")
+	opts.outputOpts.register(findCmd)
 
 	findCmd.Parse(args)
-	log.Debug(fmt.Sprintf("parsed repoUrl: %s", *repoUrl))
-	log.Debug(fmt.Sprintf("parsed noVerify: %t", *noVerify))
+	opts.repoOpts.debug()
+	opts.filterOpts.debug()
+	opts.outputOpts.debug()
+	log.Debug(fmt.Sprintf("parsed regex: %s", opts.Regex))
+	log.Debug(fmt.Sprintf("parsed range: %s", opts.Range))
 
 	var kernVer string
-	if len(findCmd.Args()) != 1 {
-		return opts, errors.New("find: missing kernel-version argument")
-	} else {
+	switch {
+	case len(findCmd.Args()) == 1:
 		kernVer = findCmd.Args()[0]
+	case len(findCmd.Args()) == 0 && (opts.Regex != "" || opts.Range != ""):
+	default:
+		return opts, errors.New("find: missing kernel-version argument")
 	}
 
-	opts.RepoUrl = *repoUrl
-	opts.NoVerify = *noVerify
 	opts.KernVer = kernVer
 
-	return opts, nil
+	return opts, opts.validate("find")
 }
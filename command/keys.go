@@ -0,0 +1,441 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"github.com/joelferrier/marsho/repository"
+	"github.com/mitchellh/cli"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+type KeysCommand struct {
+	Meta
+}
+
+func (c *KeysCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *KeysCommand) Help() string {
+	helpText := `
+Usage: marsho keys <subcommand> [options] [args]
+    Manage the repository signing keys marsho trusts
+
+    Trusted keys are kept in ~/.config/marsho/trustedkeys.gpg, or the
+    trust_store configuration setting, independent of GnuPG. Each key is
+    only trusted for the repositories it was imported or fetched for.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysCommand) Synopsis() string {
+	return "Manage trusted repository signing keys"
+}
+
+type KeysListCommand struct {
+	Meta
+}
+
+func (c *KeysListCommand) Run(args []string) int {
+	output := outputOpts{}
+	opts, _, err := keysArgs("keys list", args, 0, &output)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+	store, err := c.trustStore(opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	if output.structured() {
+		err = output.write(newKeyRecords(store.Keys()))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		return 0
+	}
+
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	table.AddRow("FINGERPRINT", "UIDS", "CREATED", "EXPIRES", "REPOSITORIES")
+	for _, key := range store.Keys() {
+		table.AddRow(key.Fingerprint, strings.Join(key.UIDs, ", "), formatDate(key.Created), formatExpiry(key.Expires), formatRepositories(key.Repositories))
+	}
+
+	fmt.Println(table)
+	fmt.Printf("\nFound %d trusted keys in %s\n", len(store.Keys()), store.Path)
+	return 0
+}
+
+func (c *KeysListCommand) Help() string {
+	helpText := `
+Usage: marsho keys list [options]
+    List trusted repository signing keys
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -output string  output format table, json, yaml or csv
+                    Default: table
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysListCommand) Synopsis() string {
+	return "List trusted repository signing keys"
+}
+
+type KeysShowCommand struct {
+	Meta
+}
+
+func (c *KeysShowCommand) Run(args []string) int {
+	opts, keyArgs, err := keysArgs("keys show", args, 1, nil)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+	store, err := c.trustStore(opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	key, ok := store.Find(keyArgs[0])
+	if !ok {
+		log.Critical(fmt.Sprintf("key %s is not trusted", keyArgs[0]))
+		return 1
+	}
+	printKey(key)
+	return 0
+}
+
+func (c *KeysShowCommand) Help() string {
+	helpText := `
+Usage: marsho keys show [options] [fingerprint]
+    Show a trusted repository signing key
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+
+    [fingerprint]   fingerprint of the key, spaces and 0x are ignored
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysShowCommand) Synopsis() string {
+	return "Show a trusted repository signing key"
+}
+
+type KeysImportCommand struct {
+	Meta
+}
+
+func (c *KeysImportCommand) Run(args []string) int {
+	opts := repoOpts{}
+	importCmd := flag.NewFlagSet("keys import", flag.ExitOnError)
+	importCmd.StringVar(&opts.ConfigPath, "config", "", "Configuration file")
+	importCmd.StringVar(&opts.RepoUrl, "repo", "", "LiME Repository url or directory")
+	importCmd.Parse(args)
+	log.Debug(fmt.Sprintf("parsed configPath: %s", opts.ConfigPath))
+	log.Debug(fmt.Sprintf("parsed repoUrl: %s", opts.RepoUrl))
+
+	files := importCmd.Args()
+	if opts.RepoUrl == "" || len(files) == 0 {
+		fmt.Printf("keys import: -repo and at least one file are required\n\n%s\n", c.Help())
+		return 1
+	}
+	store, err := c.trustStore(opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		keys, err := store.Import(data, normalizeUrl(opts.RepoUrl))
+		if err != nil {
+			log.Critical(fmt.Sprintf("error importing %s: %s", file, err))
+			return 1
+		}
+		for _, key := range keys {
+			printKey(key)
+			fmt.Println()
+		}
+	}
+
+	err = store.Save()
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	log.Info(fmt.Sprintf("trust store %s updated", store.Path))
+	return 0
+}
+
+func (c *KeysImportCommand) Help() string {
+	helpText := `
+Usage: marsho keys import [options] [file...]
+    Trust the public keys in armored, binary or GnuPG keybox files
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string    repository url, file:// url or local directory the keys
+                    are trusted for, required
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysImportCommand) Synopsis() string {
+	return "Trust public keys from a file"
+}
+
+type KeysFetchCommand struct {
+	Meta
+}
+
+func (c *KeysFetchCommand) Run(args []string) int {
+	opts := repoOpts{}
+	fetchCmd := flag.NewFlagSet("keys fetch", flag.ExitOnError)
+	fetchCmd.StringVar(&opts.ConfigPath, "config", "", "Configuration file")
+	fetchCmd.StringVar(&opts.RepoUrl, "repo", "", "LiME Repository url or directory")
+	yes := fetchCmd.Bool("yes", false, "Trust the fetched keys without confirmation")
+	fetchCmd.Parse(args)
+	opts.debug()
+	log.Debug(fmt.Sprintf("parsed yes: %t", *yes))
+
+	if len(fetchCmd.Args()) != 0 {
+		fmt.Printf("keys fetch: unexpected arguments %s\n\n%s\n",
+			strings.Join(fetchCmd.Args(), " "), c.Help())
+		return 1
+	}
+	if !*yes && !isInteractive() {
+		log.Critical("keys fetch: stdin is not a terminal, pass -yes to trust the fetched keys")
+		return 1
+	}
+
+	conf, err := c.loadConfig(opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	repos, err := repositories(conf, opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	store := repos.Repositories[0].TrustStore
+
+	trusted := 0
+	for _, repo := range repos.Repositories {
+		data, err := repo.SigningKey()
+		if err != nil {
+			log.Critical(fmt.Sprintf("error fetching signing key for %s: %s", repo.Source(), err))
+			return 1
+		}
+		keys, err := repository.ReadKeys(data)
+		if err != nil {
+			log.Critical(fmt.Sprintf("error reading signing key for %s: %s", repo.Source(), err))
+			return 1
+		}
+
+		fmt.Printf("Signing key for %s\n\n", repo.Source())
+		for _, key := range keys {
+			printKey(key)
+			fmt.Println()
+		}
+		if !*yes && !confirm(fmt.Sprintf("Trust this key for %s?", repo.Source())) {
+			fmt.Printf("Skipped %s\n\n", repo.Source())
+			continue
+		}
+		_, err = store.Import(data, repo.BaseUrl)
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+		trusted++
+	}
+
+	if trusted == 0 {
+		return 0
+	}
+	err = store.Save()
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	log.Info(fmt.Sprintf("trusted %d repository signing keys in %s", trusted, store.Path))
+	return 0
+}
+
+func (c *KeysFetchCommand) Help() string {
+	helpText := `
+Usage: marsho keys fetch [options]
+    Download repository signing keys and trust them after confirmation
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string    repository url, file:// url or local directory
+                    Default: repositories from the configuration
+    -yes            trust the fetched keys without asking, only use this
+                    when the fingerprints were verified out of band
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysFetchCommand) Synopsis() string {
+	return "Download and trust repository signing keys"
+}
+
+type KeysRemoveCommand struct {
+	Meta
+}
+
+func (c *KeysRemoveCommand) Run(args []string) int {
+	opts, keyArgs, err := keysArgs("keys remove", args, 1, nil)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+	store, err := c.trustStore(opts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	key, err := store.Remove(keyArgs[0])
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	err = store.Save()
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	log.Info(fmt.Sprintf("removed %s from %s", key.Fingerprint, store.Path))
+	return 0
+}
+
+func (c *KeysRemoveCommand) Help() string {
+	helpText := `
+Usage: marsho keys remove [options] [fingerprint]
+    Stop trusting a repository signing key
+
+    [options]
+    -config string  configuration file
+                    Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+
+    [fingerprint]   fingerprint of the key, spaces and 0x are ignored
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KeysRemoveCommand) Synopsis() string {
+	return "Stop trusting a repository signing key"
+}
+
+// keysArgs parses the flags shared by the keys subcommands, nargs is the
+// number of arguments expected and output, when not nil, takes -output
+func keysArgs(name string, args []string, nargs int, output *outputOpts) (repoOpts, []string, error) {
+	opts := repoOpts{}
+	keysCmd := flag.NewFlagSet(name, flag.ExitOnError)
+	keysCmd.StringVar(&opts.ConfigPath, "config", "", "Configuration file")
+	if output != nil {
+		output.register(keysCmd)
+	}
+	keysCmd.Parse(args)
+	log.Debug(fmt.Sprintf("parsed configPath: %s", opts.ConfigPath))
+	if output != nil {
+		output.debug()
+		err := output.validate(name)
+		if err != nil {
+			return opts, nil, err
+		}
+	}
+
+	keysArgs := keysCmd.Args()
+	switch {
+	case nargs == 0 && len(keysArgs) != 0:
+		return opts, nil, errors.New(fmt.Sprintf("%s: unexpected arguments %s", name, strings.Join(keysArgs, " ")))
+	case nargs > 0 && len(keysArgs) != nargs:
+		return opts, nil, errors.New(fmt.Sprintf("%s: missing fingerprint argument", name))
+	}
+	return opts, keysArgs, nil
+}
+
+// trustStore opens the trust store named by the configuration
+func (m *Meta) trustStore(opts repoOpts) (*repository.TrustStore, error) {
+	conf, err := m.loadConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return repository.OpenTrustStore(trustStorePath(conf))
+}
+
+// printKey prints the details an analyst needs to verify a key out of band
+func printKey(key repository.KeyInfo) {
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	table.AddRow("fingerprint:", formatFingerprint(key.Fingerprint))
+	for _, uid := range key.UIDs {
+		table.AddRow("uid:", uid)
+	}
+	table.AddRow("algorithm:", fmt.Sprintf("%s %d", key.Algorithm, key.Bits))
+	table.AddRow("created:", formatDate(key.Created))
+	table.AddRow("expires:", formatExpiry(key.Expires))
+	if len(key.Repositories) > 0 {
+		table.AddRow("repositories:", formatRepositories(key.Repositories))
+	}
+	fmt.Println(table)
+}
+
+func formatRepositories(repos []string) string {
+	if len(repos) == 0 {
+		return "none"
+	}
+	return strings.Join(repos, ", ")
+}
+
+// formatFingerprint groups a fingerprint in blocks of four the way gpg does
+func formatFingerprint(fingerprint string) string {
+	var blocks []string
+	for i := 0; i < len(fingerprint); i += 4 {
+		end := i + 4
+		if end > len(fingerprint) {
+			end = len(fingerprint)
+		}
+		blocks = append(blocks, fingerprint[i:end])
+	}
+	return strings.Join(blocks, " ")
+}
+
+func formatDate(date time.Time) string {
+	return date.UTC().Format("2006-01-02")
+}
+
+func formatExpiry(expires time.Time) string {
+	if expires.IsZero() {
+		return "never"
+	}
+	if expires.Before(time.Now()) {
+		return formatDate(expires) + " (expired)"
+	}
+	return formatDate(expires)
+}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gosuri/uitable"
@@ -14,8 +15,15 @@ type ListCommand struct {
 }
 
 type listOpts struct {
-	RepoUrl  string
-	NoVerify bool
+	repoOpts
+	filterOpts
+	outputOpts
+	Sort    string
+	Columns string
+	Limit   int
+	Offset  int
+	NoPager bool
+	columns []string
 }
 
 func (c *ListCommand) setHelp() {
@@ -24,9 +32,33 @@ Usage: marsho list [options]
     List availible LiME kernel modules
 
     [options]
-    -repo string   repository url
-                   Default: https://threatresponse-lime-modules.s3.amazonaws.com/
-    -gpg-no-verify disable GPG Verification
+    -config string    configuration file
+                      Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string      repository url, file:// url or local directory
+                      Default: repositories from the configuration
+                      or https://threatresponse-lime-modules.s3.amazonaws.com/
+    -keyring string   keyring file, armored, binary or GnuPG keybox
+                      Default: gnupg, $GNUPGHOME/pubring.kbx or pubring.gpg
+    -gpg-no-verify    disable GPG Verification
+    -refresh          ignore cached repository metadata
+    -offline          use cached repository metadata only
+    -replay           accept rolled back or stale repository metadata
+    -arch string      only modules for this architecture eg. aarch64
+    -platform string  only modules for this platform eg. linux
+    -type string      only modules of this type eg. lime
+    -packager string  only modules built by this packager eg. lime-compiler
+                      Globs are supported by every filter
+    -output string    output format table, json, yaml or csv
+                      Default: table
+    -sort string      sort by version, name, arch, platform, type,
+                      packager or source
+                      Default: repository order
+    -columns string   comma separated columns for table and csv output
+                      eg. version,arch,location
+    -limit int        list at most this many modules
+    -offset int       skip this many modules
+    -no-pager         never page output through $PAGER, only table
+                      output is paged
 `
 }
 
@@ -34,35 +66,78 @@ func (c *ListCommand) Run(args []string) int {
 	opts, err := listArgs(args)
 	if err != nil {
 		fmt.Printf("%s\n\n%s\n", err, c.Help())
-		return 0
+		return 1
 	}
-	repo := repository.DefaultRepository()
-	if opts.RepoUrl != "" {
-		// ensure repo url has a trailing slash
-		if opts.RepoUrl[len(opts.RepoUrl)-1:] != "/" {
-			opts.RepoUrl = opts.RepoUrl + "/"
-		}
-		repo.BaseUrl = opts.RepoUrl
+	conf, err := c.loadConfig(opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	repos, err := repositories(conf, opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
 	}
-	repo.SkipGPGVerify = opts.NoVerify
 
-	//manifest, err := repository.List(conf)
-	manifest, err := repo.List()
+	modules, err := repos.Filter(opts.filter(""))
 	if err != nil {
 		log.Critical(err)
+		return 1
+	}
+	if opts.Sort != "" {
+		err = repository.SortModules(modules, opts.Sort)
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+	}
+	found := len(modules)
+	modules = opts.page(modules)
+
+	// only the table is paged, structured output is meant for other programs
+	out, wait := pager(opts.NoPager || opts.structured())
+	defer wait()
+
+	records := newModuleRecords(modules)
+	switch {
+	case opts.Output == "json" || opts.Output == "yaml":
+		err = writeRecords(out, opts.Output, records)
+	case len(opts.columns) > 0 && opts.Output == "csv":
+		err = writeRecords(out, opts.Output, selectColumns(records, opts.columns))
+	case opts.Output == "csv":
+		err = writeRecords(out, opts.Output, records)
+	case len(opts.columns) > 0:
+		fmt.Fprintln(out, selectColumns(records, opts.columns).table())
+	default:
+		fmt.Fprintln(out, moduleTable(modules, len(repos.Repositories) > 1))
+	}
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	if opts.structured() {
 		return 0
 	}
 
-	table := uitable.New()
-	table.MaxColWidth = 80
-	table.Wrap = true
-	for _, mod := range manifest.Modules {
-		table.AddRow(fmt.Sprintf("kernel: %s", mod.Version), fmt.Sprintf("path: /modules/%s", mod.Name))
+	sources := strings.Join(repos.Sources(), ", ")
+	if len(modules) == found {
+		fmt.Fprintf(out, "\nFound %d LiME modules in %s\n", found, sources)
+	} else {
+		fmt.Fprintf(out, "\nShowing %d of %d LiME modules in %s from offset %d\n", len(modules), found, sources, opts.Offset)
 	}
+	return 0
+}
 
-	fmt.Println(table)
-	fmt.Printf("\nFound %d LiME modules in %s\n", len(manifest.Modules), repo.BaseUrl)
-	return 1
+// page returns the modules selected by -offset and -limit
+func (o *listOpts) page(modules []repository.Module) []repository.Module {
+	if o.Offset >= len(modules) {
+		return nil
+	}
+	modules = modules[o.Offset:]
+	if o.Limit > 0 && o.Limit < len(modules) {
+		modules = modules[:o.Limit]
+	}
+	return modules
 }
 
 func (c *ListCommand) Help() string {
@@ -78,15 +153,67 @@ func listArgs(args []string) (listOpts, error) {
 	opts := listOpts{}
 
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
-	repoUrl := listCmd.String("repo", "", "LiME Repository url")
-	noVerify := listCmd.Bool("gpg-no-verify", false, "Disable GPG Verification")
+	opts.repoOpts.register(listCmd)
+	opts.filterOpts.register(listCmd)
+	opts.outputOpts.register(listCmd)
+	listCmd.StringVar(&opts.Sort, "sort", "", "Sort field")
+	listCmd.StringVar(&opts.Columns, "columns", "", "Columns to show")
+	listCmd.IntVar(&opts.Limit, "limit", 0, "Maximum number of modules")
+	listCmd.IntVar(&opts.Offset, "offset", 0, "Number of modules to skip")
+	listCmd.BoolVar(&opts.NoPager, "no-pager", false, "Disable paging")
 
 	listCmd.Parse(args)
-	log.Debug(fmt.Sprintf("parsed repoUrl: %s", *repoUrl))
-	log.Debug(fmt.Sprintf("parsed noVerify: %t", *noVerify))
+	opts.repoOpts.debug()
+	opts.filterOpts.debug()
+	opts.outputOpts.debug()
+	log.Debug(fmt.Sprintf("parsed sort: %s", opts.Sort))
+	log.Debug(fmt.Sprintf("parsed columns: %s", opts.Columns))
+	log.Debug(fmt.Sprintf("parsed limit: %d offset: %d", opts.Limit, opts.Offset))
+	log.Debug(fmt.Sprintf("parsed no-pager: %t", opts.NoPager))
+
+	err := opts.validate("list")
+	if err != nil {
+		return opts, err
+	}
+	if opts.Sort != "" && !sortField(opts.Sort) {
+		return opts, errors.New(fmt.Sprintf(
+			"list: unknown -sort %s, expected one of %s", opts.Sort, strings.Join(repository.SortFields, ", "),
+		))
+	}
+	if opts.Limit < 0 || opts.Offset < 0 {
+		return opts, errors.New("list: -limit and -offset must not be negative")
+	}
+	if opts.Columns != "" {
+		opts.columns, err = parseColumns(opts.Columns)
+		if err != nil {
+			return opts, errors.New(fmt.Sprintf("list: %s", err))
+		}
+	}
+	return opts, nil
+}
 
-	opts.RepoUrl = *repoUrl
-	opts.NoVerify = *noVerify
+// sortField reports whether modules can be sorted by field
+func sortField(field string) bool {
+	for _, name := range repository.SortFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
 
-	return opts, nil
+// moduleTable lists modules by kernel version and path, naming the source
+// repository when several are searched
+func moduleTable(modules []repository.Module, showSource bool) *uitable.Table {
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	for _, mod := range modules {
+		if showSource {
+			table.AddRow(fmt.Sprintf("kernel: %s", mod.Version), fmt.Sprintf("path: /modules/%s", mod.Name), fmt.Sprintf("repo: %s", mod.Source))
+		} else {
+			table.AddRow(fmt.Sprintf("kernel: %s", mod.Version), fmt.Sprintf("path: /modules/%s", mod.Name))
+		}
+	}
+	return table
 }
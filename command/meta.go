@@ -2,5 +2,6 @@ package command
 
 //This is how meta variables are passed to commands
 type Meta struct {
-	Color bool
+	Color   bool
+	Verbose bool
 }
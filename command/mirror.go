@@ -0,0 +1,177 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"strings"
+)
+
+type MirrorCommand struct {
+	Meta
+	HelpText string
+}
+
+type mirrorOpts struct {
+	repoOpts
+	filterOpts
+	outputOpts
+	Workers int
+	Dest    string
+	KernVer string
+}
+
+func (c *MirrorCommand) setHelp() {
+	c.HelpText = `
+Usage: marsho mirror [options] [destination] [kernel-version]
+    Mirror a LiME repository to a local directory
+
+    [options]
+    -config string    configuration file
+                      Default: ~/.config/marsho/config.yaml or $MARSHO_CONFIG
+    -repo string      repository url, file:// url or local directory
+                      Default: first repository from the configuration
+                      or https://threatresponse-lime-modules.s3.amazonaws.com/
+    -keyring string   keyring file, armored, binary or GnuPG keybox
+                      Default: gnupg, $GNUPGHOME/pubring.kbx or pubring.gpg
+    -gpg-no-verify    disable GPG Verification
+    -refresh          ignore cached repository metadata
+    -replay           accept rolled back or stale repository metadata
+    -arch string      only mirror modules for this architecture eg. x86_64
+    -platform string  only mirror modules for this platform eg. linux
+    -type string      only mirror modules of this type eg. lime
+    -packager string  only mirror modules built by this packager
+                      Globs are supported by every filter
+    -workers int      number of concurrent downloads
+                      Default: 4
+    -output string    output format table, json, yaml or csv
+                      Default: table
+
+    [destination]     directory to mirror the repository into, modules
+                      already present with a matching checksum are skipped
+                      Repository metadata is only copied once every module
+                      is mirrored, a filtered mirror keeps the upstream
+                      metadata and modules it left out fail to download
+    [kernel-version]  only mirror modules matching this kernel version
+                      Globs are supported eg. '4.4.*amzn1*'
+`
+}
+
+func (c *MirrorCommand) Run(args []string) int {
+	opts, err := mirrorArgs(args)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+	conf, err := c.loadConfig(opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	repos, err := repositories(conf, opts.repoOpts)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+	// mirror replicates a single repository, the highest priority one
+	repo := repos.Repositories[0]
+
+	results, err := repo.Mirror(opts.Dest, opts.filter(opts.KernVer), opts.Workers)
+	if err != nil {
+		log.Critical(err)
+		return 1
+	}
+
+	if opts.structured() {
+		err = opts.write(newFetchRecords(results))
+		if err != nil {
+			log.Critical(err)
+			return 1
+		}
+	}
+
+	fetched, current, failed := 0, 0, 0
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			table.AddRow("failed", fmt.Sprintf("kernel: %s", result.Module.Version), result.Err)
+		case result.UpToDate:
+			current++
+			table.AddRow("current", fmt.Sprintf("kernel: %s", result.Module.Version), fmt.Sprintf("path: %s", result.Path))
+		default:
+			fetched++
+			table.AddRow("fetched", fmt.Sprintf("kernel: %s", result.Module.Version), fmt.Sprintf("path: %s", result.Path))
+		}
+	}
+
+	if !opts.structured() {
+		fmt.Println(table)
+		fmt.Printf("\nMirrored %d LiME modules from %s to %s (%d fetched, %d up to date, %d failed)\n",
+			len(results), repo.BaseUrl, opts.Dest, fetched, current, failed)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (c *MirrorCommand) Help() string {
+	c.setHelp()
+	return strings.TrimSpace(c.HelpText)
+}
+
+func (c *MirrorCommand) Synopsis() string {
+	return "Mirror a LiME repository to a local directory"
+}
+
+func mirrorArgs(args []string) (mirrorOpts, error) {
+	opts := mirrorOpts{}
+
+	mirrorCmd := flag.NewFlagSet("mirror", flag.ExitOnError)
+	opts.repoOpts.register(mirrorCmd)
+	opts.filterOpts.register(mirrorCmd)
+	opts.outputOpts.register(mirrorCmd)
+	workers := mirrorCmd.Int("workers", 4, "Concurrent downloads")
+
+	mirrorCmd.Parse(args)
+	opts.repoOpts.debug()
+	opts.filterOpts.debug()
+	opts.outputOpts.debug()
+	log.Debug(fmt.Sprintf("parsed workers: %d", *workers))
+
+	var dest string
+	var kernVer string
+	switch len(mirrorCmd.Args()) {
+	case 1:
+		dest = mirrorCmd.Args()[0]
+	case 2:
+		dest = mirrorCmd.Args()[0]
+		kernVer = mirrorCmd.Args()[1]
+	default:
+		return opts, errors.New("mirror: missing destination argument")
+	}
+	log.Debug(fmt.Sprintf("parsed dest: %s", dest))
+	log.Debug(fmt.Sprintf("parsed kernVer: %s", kernVer))
+
+	if *workers < 1 {
+		return opts, errors.New("mirror: -workers must be at least 1")
+	}
+	if opts.Offline {
+		return opts, errors.New("mirror: -offline is not supported")
+	}
+	err := opts.validate("mirror")
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Workers = *workers
+	opts.Dest = dest
+	opts.KernVer = kernVer
+
+	return opts, nil
+}
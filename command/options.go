@@ -0,0 +1,165 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/joelferrier/marsho/config"
+	"github.com/joelferrier/marsho/repository"
+	"github.com/op/go-logging"
+	"strings"
+)
+
+// repoOpts are the options shared by commands that read from one or more
+// repositories
+type repoOpts struct {
+	ConfigPath string
+	RepoUrl    string
+	Keyring    string
+	NoVerify   bool
+	Refresh    bool
+	Offline    bool
+	Replay     bool
+}
+
+// register adds the shared repository flags to a command's flag set
+func (o *repoOpts) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigPath, "config", "", "Configuration file")
+	fs.StringVar(&o.RepoUrl, "repo", "", "LiME Repository url or directory")
+	fs.StringVar(&o.Keyring, "keyring", "", "Keyring file, armored, binary or keybox")
+	fs.BoolVar(&o.NoVerify, "gpg-no-verify", false, "Disable GPG Verification")
+	fs.BoolVar(&o.Refresh, "refresh", false, "Ignore cached repository metadata")
+	fs.BoolVar(&o.Offline, "offline", false, "Use cached repository metadata only")
+	fs.BoolVar(&o.Replay, "replay", false, "Accept rolled back or stale repository metadata")
+}
+
+func (o *repoOpts) debug() {
+	log.Debug(fmt.Sprintf("parsed configPath: %s", o.ConfigPath))
+	log.Debug(fmt.Sprintf("parsed repoUrl: %s", o.RepoUrl))
+	log.Debug(fmt.Sprintf("parsed keyring: %s", o.Keyring))
+	log.Debug(fmt.Sprintf("parsed noVerify: %t", o.NoVerify))
+	log.Debug(fmt.Sprintf("parsed refresh: %t", o.Refresh))
+	log.Debug(fmt.Sprintf("parsed offline: %t", o.Offline))
+	log.Debug(fmt.Sprintf("parsed replay: %t", o.Replay))
+}
+
+// filterOpts are the module filters shared by commands that search
+// repositories, each accepts a glob
+type filterOpts struct {
+	Arch     string
+	Platform string
+	Type     string
+	Packager string
+}
+
+// register adds the module filter flags to a command's flag set
+func (o *filterOpts) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Arch, "arch", "", "Module architecture")
+	fs.StringVar(&o.Platform, "platform", "", "Module platform")
+	fs.StringVar(&o.Type, "type", "", "Module type")
+	fs.StringVar(&o.Packager, "packager", "", "Module packager")
+}
+
+func (o *filterOpts) debug() {
+	log.Debug(fmt.Sprintf("parsed arch: %s", o.Arch))
+	log.Debug(fmt.Sprintf("parsed platform: %s", o.Platform))
+	log.Debug(fmt.Sprintf("parsed type: %s", o.Type))
+	log.Debug(fmt.Sprintf("parsed packager: %s", o.Packager))
+}
+
+// filter returns a repository filter for kernVer and the module filters
+func (o *filterOpts) filter(kernVer string) repository.Filter {
+	return repository.Filter{
+		Version:  kernVer,
+		Arch:     o.Arch,
+		Platform: o.Platform,
+		Type:     o.Type,
+		Packager: o.Packager,
+	}
+}
+
+// loadConfig merges the configuration file, MARSHO_* environment variables
+// and command line flags, then applies the global settings
+func (m *Meta) loadConfig(opts repoOpts) (config.Config, error) {
+	conf, err := config.Load(config.Path(opts.ConfigPath))
+	if err != nil {
+		return conf, err
+	}
+	if opts.RepoUrl != "" {
+		err = conf.Set("repositories", opts.RepoUrl, "flag -repo")
+		if err != nil {
+			return conf, err
+		}
+	}
+	if opts.Keyring != "" {
+		err = conf.Set("keyring", opts.Keyring, "flag -keyring")
+		if err != nil {
+			return conf, err
+		}
+	}
+
+	// --verbose always wins over the configured log level
+	if !m.Verbose {
+		level, err := logging.LogLevel(conf.LogLevel)
+		if err == nil {
+			logging.SetLevel(level, "")
+		}
+	}
+
+	err = repository.SetHTTPOptions(conf.Timeout(), conf.Proxy)
+	if err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// repositories builds the repository group described by the configuration
+func repositories(conf config.Config, opts repoOpts) (*repository.Group, error) {
+	store, err := repository.OpenTrustStore(trustStorePath(conf))
+	if err != nil {
+		return nil, err
+	}
+
+	group := &repository.Group{}
+	for _, repoConf := range conf.Repositories {
+		repo := repository.DefaultRepository()
+		repo.Name = repoConf.Name
+		repo.BaseUrl = normalizeUrl(repoConf.Url)
+		repo.KeyPath = repoConf.SigningKey
+		repo.TUFRoot = repoConf.TUFRoot
+		repo.KeyringPath = conf.Keyring
+		// pins are the built in defaults for the repository, followed by
+		// its own fingerprints and the globally trusted ones
+		repo.Fingerprints = repository.DefaultFingerprints(repo.BaseUrl)
+		repo.Fingerprints = append(repo.Fingerprints, repoConf.Fingerprints...)
+		repo.Fingerprints = append(repo.Fingerprints, conf.TrustedFingerprints...)
+		repo.DownloadDir = conf.DownloadDir
+		repo.TrustStore = store
+		repo.Policy = repository.Policy{
+			AllowWeakCrypto:  conf.AllowWeakCrypto,
+			AllowExpiredKeys: conf.AllowExpiredKeys,
+		}
+		repo.SkipGPGVerify = opts.NoVerify
+		repo.Refresh = opts.Refresh
+		repo.Offline = opts.Offline
+		repo.Replay = opts.Replay
+		repo.MaxMetadataAge = conf.MetadataAge()
+		group.Repositories = append(group.Repositories, &repo)
+	}
+	return group, nil
+}
+
+// trustStorePath returns the configured trust store or the per-user default
+func trustStorePath(conf config.Config) string {
+	if conf.TrustStore != "" {
+		return conf.TrustStore
+	}
+	return repository.DefaultTrustStorePath()
+}
+
+// normalizeUrl ensures a repository url has a trailing slash
+func normalizeUrl(url string) string {
+	if !strings.HasSuffix(url, "/") {
+		return url + "/"
+	}
+	return url
+}
@@ -0,0 +1,355 @@
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"github.com/joelferrier/marsho/config"
+	"github.com/joelferrier/marsho/repository"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"strings"
+)
+
+// outputFormats are the formats accepted by -output, table is meant for
+// people and the others for automation
+var outputFormats = []string{"table", "json", "yaml", "csv"}
+
+type outputOpts struct {
+	Output string
+}
+
+// register adds the -output flag to a command's flag set
+func (o *outputOpts) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Output, "output", "table", "Output format")
+}
+
+func (o *outputOpts) debug() {
+	log.Debug(fmt.Sprintf("parsed output: %s", o.Output))
+}
+
+// validate checks the -output format for the named command
+func (o *outputOpts) validate(name string) error {
+	for _, format := range outputFormats {
+		if o.Output == format {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf(
+		"%s: unknown -output %s, expected one of %s", name, o.Output, strings.Join(outputFormats, ", "),
+	))
+}
+
+// structured reports whether a machine readable format was requested, the
+// prose summary lines are left out of structured output
+func (o *outputOpts) structured() bool {
+	return o.Output != "table"
+}
+
+// records are rows of named columns that can also be written as csv
+type records interface {
+	header() []string
+	rows() [][]string
+}
+
+// write prints value to stdout as json, yaml or csv
+func (o *outputOpts) write(value records) error {
+	return writeRecords(os.Stdout, o.Output, value)
+}
+
+func writeRecords(w io.Writer, format string, value records) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	case "yaml":
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(value.header())
+		writer.WriteAll(value.rows())
+		return writer.Error()
+	}
+	return errors.New(fmt.Sprintf("unknown output format %s", format))
+}
+
+// moduleRecord is the machine readable form of a repository.Module
+type moduleRecord struct {
+	Name         string `json:"name" yaml:"name"`
+	Version      string `json:"version" yaml:"version"`
+	Arch         string `json:"arch" yaml:"arch"`
+	Platform     string `json:"platform" yaml:"platform"`
+	Type         string `json:"type" yaml:"type"`
+	Packager     string `json:"packager" yaml:"packager"`
+	Checksum     string `json:"checksum" yaml:"checksum"`
+	ChecksumType string `json:"checksum_type" yaml:"checksum_type"`
+	Location     string `json:"location" yaml:"location"`
+	Signature    string `json:"signature" yaml:"signature"`
+	Source       string `json:"source" yaml:"source"`
+}
+
+var moduleHeader = []string{
+	"name", "version", "arch", "platform", "type", "packager",
+	"checksum", "checksum_type", "location", "signature", "source",
+}
+
+func newModuleRecord(mod repository.Module) moduleRecord {
+	return moduleRecord{
+		Name:         mod.Name,
+		Version:      mod.Version,
+		Arch:         mod.Arch,
+		Platform:     mod.Platform,
+		Type:         mod.ModuleType,
+		Packager:     mod.Packager,
+		Checksum:     mod.Checksum.Value,
+		ChecksumType: mod.Checksum.Algorithm(),
+		Location:     mod.Location.Href,
+		Signature:    mod.Signature.Href,
+		Source:       mod.Source,
+	}
+}
+
+func (m moduleRecord) values() []string {
+	return []string{
+		m.Name, m.Version, m.Arch, m.Platform, m.Type, m.Packager,
+		m.Checksum, m.ChecksumType, m.Location, m.Signature, m.Source,
+	}
+}
+
+type moduleRecords []moduleRecord
+
+func newModuleRecords(modules []repository.Module) moduleRecords {
+	records := moduleRecords{}
+	for _, mod := range modules {
+		records = append(records, newModuleRecord(mod))
+	}
+	return records
+}
+
+func (m moduleRecords) header() []string {
+	return moduleHeader
+}
+
+func (m moduleRecords) rows() [][]string {
+	var rows [][]string
+	for _, record := range m {
+		rows = append(rows, record.values())
+	}
+	return rows
+}
+
+// fetchRecord is the machine readable outcome of a download, status is
+// fetched, current or failed and verified is gpg, tuf or none
+type fetchRecord struct {
+	moduleRecord `yaml:",inline"`
+	Path         string `json:"path" yaml:"path"`
+	Status       string `json:"status" yaml:"status"`
+	Verified     string `json:"verified" yaml:"verified"`
+	Error        string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newFetchRecord(result repository.FetchResult) fetchRecord {
+	record := fetchRecord{
+		moduleRecord: newModuleRecord(result.Module),
+		Path:         result.Path,
+		Status:       "fetched",
+		Verified:     result.Verified,
+	}
+	switch {
+	case result.Err != nil:
+		record.Status = "failed"
+		record.Error = result.Err.Error()
+	case result.UpToDate:
+		record.Status = "current"
+	}
+	if record.Verified == "" {
+		record.Verified = "none"
+	}
+	return record
+}
+
+type fetchRecords []fetchRecord
+
+func newFetchRecords(results []repository.FetchResult) fetchRecords {
+	records := fetchRecords{}
+	for _, result := range results {
+		records = append(records, newFetchRecord(result))
+	}
+	return records
+}
+
+func (f fetchRecords) header() []string {
+	return append(append([]string{}, moduleHeader...), "path", "status", "verified", "error")
+}
+
+func (f fetchRecords) rows() [][]string {
+	var rows [][]string
+	for _, record := range f {
+		rows = append(rows, append(record.values(), record.Path, record.Status, record.Verified, record.Error))
+	}
+	return rows
+}
+
+// keyRecord is the machine readable form of a trusted repository.KeyInfo,
+// expires is empty for keys that never expire
+type keyRecord struct {
+	Fingerprint  string   `json:"fingerprint" yaml:"fingerprint"`
+	UIDs         []string `json:"uids" yaml:"uids"`
+	Algorithm    string   `json:"algorithm" yaml:"algorithm"`
+	Bits         int      `json:"bits" yaml:"bits"`
+	Created      string   `json:"created" yaml:"created"`
+	Expires      string   `json:"expires" yaml:"expires"`
+	Repositories []string `json:"repositories" yaml:"repositories"`
+}
+
+type keyRecords []keyRecord
+
+func newKeyRecords(keys []repository.KeyInfo) keyRecords {
+	records := keyRecords{}
+	for _, key := range keys {
+		record := keyRecord{
+			Fingerprint:  key.Fingerprint,
+			UIDs:         key.UIDs,
+			Algorithm:    key.Algorithm,
+			Bits:         key.Bits,
+			Created:      formatDate(key.Created),
+			Repositories: append([]string{}, key.Repositories...),
+		}
+		if !key.Expires.IsZero() {
+			record.Expires = formatDate(key.Expires)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (k keyRecords) header() []string {
+	return []string{"fingerprint", "uids", "algorithm", "bits", "created", "expires", "repositories"}
+}
+
+// rows joins uids and repositories with ; as both may contain commas
+func (k keyRecords) rows() [][]string {
+	var rows [][]string
+	for _, record := range k {
+		rows = append(rows, []string{
+			record.Fingerprint, strings.Join(record.UIDs, ";"), record.Algorithm,
+			fmt.Sprintf("%d", record.Bits), record.Created, record.Expires,
+			strings.Join(record.Repositories, ";"),
+		})
+	}
+	return rows
+}
+
+// settingRecord is the machine readable form of a config.Setting
+type settingRecord struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+type settingRecords []settingRecord
+
+func newSettingRecords(settings []config.Setting) settingRecords {
+	records := settingRecords{}
+	for _, setting := range settings {
+		records = append(records, settingRecord{setting.Key, setting.Value, setting.Origin})
+	}
+	return records
+}
+
+func (s settingRecords) header() []string {
+	return []string{"key", "value", "source"}
+}
+
+func (s settingRecords) rows() [][]string {
+	var rows [][]string
+	for _, record := range s {
+		rows = append(rows, []string{record.Key, record.Value, record.Source})
+	}
+	return rows
+}
+
+// parseColumns splits a comma separated list of module columns
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		if columnIndex(moduleHeader, column) < 0 {
+			return nil, errors.New(fmt.Sprintf(
+				"unknown column %s, expected any of %s", column, strings.Join(moduleHeader, ", "),
+			))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func columnIndex(header []string, column string) int {
+	for i, name := range header {
+		if name == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnRecords are the chosen columns of other records
+type columnRecords struct {
+	columns []string
+	body    [][]string
+}
+
+// selectColumns keeps only columns of value in the order given
+func selectColumns(value records, columns []string) columnRecords {
+	header := value.header()
+	selected := columnRecords{columns: columns}
+	for _, row := range value.rows() {
+		var cells []string
+		for _, column := range columns {
+			cells = append(cells, row[columnIndex(header, column)])
+		}
+		selected.body = append(selected.body, cells)
+	}
+	return selected
+}
+
+func (c columnRecords) header() []string {
+	return c.columns
+}
+
+func (c columnRecords) rows() [][]string {
+	return c.body
+}
+
+// table lays the columns out with an upper case heading row
+func (c columnRecords) table() *uitable.Table {
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	var heading []interface{}
+	for _, column := range c.columns {
+		heading = append(heading, strings.ToUpper(column))
+	}
+	table.AddRow(heading...)
+	for _, row := range c.body {
+		var cells []interface{}
+		for _, cell := range row {
+			cells = append(cells, cell)
+		}
+		table.AddRow(cells...)
+	}
+	return table
+}
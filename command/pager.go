@@ -0,0 +1,35 @@
+package command
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// pager returns the writer long output goes to, piping it through $PAGER
+// when stdout is a terminal, wait closes the pager's input and waits for
+// it to exit
+func pager(disabled bool) (io.Writer, func()) {
+	command := os.Getenv("PAGER")
+	if disabled || command == "" || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return os.Stdout, func() {}
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to start pager %s: %s", command, err))
+		return os.Stdout, func() {}
+	}
+	return stdin, func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+}
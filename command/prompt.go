@@ -0,0 +1,62 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/gosuri/uitable"
+	"github.com/joelferrier/marsho/repository"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isInteractive reports whether stdin is attached to a terminal
+func isInteractive() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptModule prints a numbered list of modules and reads the analyst's
+// choice from stdin, it satisfies repository.Selector
+func promptModule(modules []repository.Module) (repository.Module, error) {
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.Wrap = true
+	table.AddRow("", "VERSION", "ARCH", "PLATFORM", "PACKAGER")
+	for i, mod := range modules {
+		table.AddRow(fmt.Sprintf("[%d]", i+1), mod.Version, mod.Arch, mod.Platform, mod.Packager)
+	}
+	fmt.Println(table)
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("\nSelect a module [1-%d] (q to abort): ", len(modules))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return repository.Module{}, errors.New("fetch: no module selected")
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "q" {
+			return repository.Module{}, errors.New("fetch: aborted")
+		}
+		choice, err := strconv.Atoi(line)
+		if err != nil || choice < 1 || choice > len(modules) {
+			fmt.Printf("invalid selection: %s\n", line)
+			continue
+		}
+		return modules[choice-1], nil
+	}
+}
+
+// confirm asks a yes/no question on stdin, anything but yes is a no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,26 @@
+package command
+
+import (
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+type RepoCommand struct {
+	Meta
+}
+
+func (c *RepoCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *RepoCommand) Help() string {
+	helpText := `
+Usage: marsho repo <subcommand> [options] [args]
+    Publish and maintain LiME repositories
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *RepoCommand) Synopsis() string {
+	return "Publish and maintain LiME repositories"
+}
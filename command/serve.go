@@ -0,0 +1,111 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joelferrier/marsho/repository"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ServeCommand struct {
+	Meta
+	HelpText string
+}
+
+type serveOpts struct {
+	Dir     string
+	Listen  string
+	TLSCert string
+	TLSKey  string
+}
+
+func (c *ServeCommand) setHelp() {
+	c.HelpText = `
+Usage: marsho serve [options]
+    Serve a local LiME repository over HTTP
+
+    [options]
+    -dir string       repository directory created by mirror or repo build
+                      Default: .
+    -listen string    address to listen on
+                      Default: :8080
+    -tls-cert string  certificate file, serve HTTPS when set with -tls-key
+    -tls-key string   private key file for -tls-cert
+`
+}
+
+func (c *ServeCommand) Run(args []string) int {
+	opts, err := serveArgs(args)
+	if err != nil {
+		fmt.Printf("%s\n\n%s\n", err, c.Help())
+		return 1
+	}
+
+	repomd := filepath.Join(opts.Dir, "repodata", "repomd.xml")
+	if _, err := os.Stat(repomd); err != nil {
+		log.Warning(fmt.Sprintf("%s not found, %s may not be a LiME repository", repomd, opts.Dir))
+	}
+
+	// clients only send small GET requests, there is no write timeout so
+	// large modules can still be downloaded over slow links
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           repository.FileServer(opts.Dir),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	if opts.TLSCert != "" {
+		log.Info(fmt.Sprintf("serving %s on https://%s/", opts.Dir, opts.Listen))
+		err = server.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+	} else {
+		log.Info(fmt.Sprintf("serving %s on http://%s/", opts.Dir, opts.Listen))
+		err = server.ListenAndServe()
+	}
+	log.Critical(err)
+	return 1
+}
+
+func (c *ServeCommand) Help() string {
+	c.setHelp()
+	return strings.TrimSpace(c.HelpText)
+}
+
+func (c *ServeCommand) Synopsis() string {
+	return "Serve a local LiME repository over HTTP"
+}
+
+func serveArgs(args []string) (serveOpts, error) {
+	opts := serveOpts{}
+
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := serveCmd.String("dir", ".", "Repository directory")
+	listen := serveCmd.String("listen", ":8080", "Listen address")
+	tlsCert := serveCmd.String("tls-cert", "", "TLS certificate file")
+	tlsKey := serveCmd.String("tls-key", "", "TLS private key file")
+
+	serveCmd.Parse(args)
+	log.Debug(fmt.Sprintf("parsed dir: %s", *dir))
+	log.Debug(fmt.Sprintf("parsed listen: %s", *listen))
+	log.Debug(fmt.Sprintf("parsed tlsCert: %s", *tlsCert))
+	log.Debug(fmt.Sprintf("parsed tlsKey: %s", *tlsKey))
+
+	if len(serveCmd.Args()) != 0 {
+		return opts, errors.New("serve: unexpected arguments")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return opts, errors.New("serve: -tls-cert and -tls-key must be used together")
+	}
+
+	opts.Dir = *dir
+	opts.Listen = *listen
+	opts.TLSCert = *tlsCert
+	opts.TLSKey = *tlsKey
+
+	return opts, nil
+}
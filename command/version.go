@@ -18,7 +18,7 @@ func (c *VersionCommand) Run(args []string) int {
 	fmt.Printf("marsho v%s\n", c.Version)
 	fmt.Printf("git commit hash: %s\n", c.Revision)
 	fmt.Printf("build time: %s\n", c.BuildTime)
-	return 1
+	return 0
 }
 
 func (c *VersionCommand) Synopsis() string {
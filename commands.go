@@ -7,6 +7,9 @@ import (
 
 var Commands map[string]cli.CommandFactory
 
+// meta is shared with every command, main fills it in before running
+var meta command.Meta
+
 func init() {
 
 	Commands = map[string]cli.CommandFactory{
@@ -19,16 +22,64 @@ func init() {
 			}, nil
 		},
 
+		"config": func() (cli.Command, error) {
+			return &command.ConfigCommand{Meta: meta}, nil
+		},
+
+		"config show": func() (cli.Command, error) {
+			return &command.ConfigShowCommand{Meta: meta}, nil
+		},
+
 		"fetch": func() (cli.Command, error) {
-			return &command.FetchCommand{}, nil
+			return &command.FetchCommand{Meta: meta}, nil
 		},
 
 		"find": func() (cli.Command, error) {
-			return &command.FindCommand{}, nil
+			return &command.FindCommand{Meta: meta}, nil
+		},
+
+		"keys": func() (cli.Command, error) {
+			return &command.KeysCommand{Meta: meta}, nil
+		},
+
+		"keys list": func() (cli.Command, error) {
+			return &command.KeysListCommand{Meta: meta}, nil
+		},
+
+		"keys show": func() (cli.Command, error) {
+			return &command.KeysShowCommand{Meta: meta}, nil
+		},
+
+		"keys import": func() (cli.Command, error) {
+			return &command.KeysImportCommand{Meta: meta}, nil
+		},
+
+		"keys fetch": func() (cli.Command, error) {
+			return &command.KeysFetchCommand{Meta: meta}, nil
+		},
+
+		"keys remove": func() (cli.Command, error) {
+			return &command.KeysRemoveCommand{Meta: meta}, nil
 		},
 
 		"list": func() (cli.Command, error) {
-			return &command.ListCommand{}, nil
+			return &command.ListCommand{Meta: meta}, nil
+		},
+
+		"mirror": func() (cli.Command, error) {
+			return &command.MirrorCommand{Meta: meta}, nil
+		},
+
+		"repo": func() (cli.Command, error) {
+			return &command.RepoCommand{Meta: meta}, nil
+		},
+
+		"repo build": func() (cli.Command, error) {
+			return &command.BuildCommand{Meta: meta}, nil
+		},
+
+		"serve": func() (cli.Command, error) {
+			return &command.ServeCommand{Meta: meta}, nil
 		},
 	}
 }
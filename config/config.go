@@ -0,0 +1,361 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repository configures one LiME repository, repositories are searched in
+// the order they are listed
+type Repository struct {
+	Name         string   `yaml:"name"`
+	Url          string   `yaml:"url"`
+	SigningKey   string   `yaml:"signing_key,omitempty"`
+	Fingerprints []string `yaml:"fingerprints,omitempty"`
+	TUFRoot      string   `yaml:"tuf_root,omitempty"`
+}
+
+// Config holds global settings merged from defaults, the configuration
+// file, MARSHO_* environment variables and command line flags, in that
+// order of precedence
+type Config struct {
+	Repositories        []Repository `yaml:"repositories"`
+	Keyring             string       `yaml:"keyring"`
+	TrustStore          string       `yaml:"trust_store"`
+	TrustedFingerprints []string     `yaml:"trusted_fingerprints"`
+	AllowWeakCrypto     bool         `yaml:"allow_weak_crypto"`
+	AllowExpiredKeys    bool         `yaml:"allow_expired_keys"`
+	MaxMetadataAge      string       `yaml:"max_metadata_age"`
+	DownloadDir         string       `yaml:"download_dir"`
+	HTTPTimeout         string       `yaml:"http_timeout"`
+	Proxy               string       `yaml:"proxy"`
+	LogLevel            string       `yaml:"log_level"`
+
+	origins map[string]string
+}
+
+// Setting is a single effective configuration value and where it came from
+type Setting struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// Keys lists every configuration key in display order
+var Keys = []string{
+	"repositories",
+	"keyring",
+	"trust_store",
+	"trusted_fingerprints",
+	"allow_weak_crypto",
+	"allow_expired_keys",
+	"max_metadata_age",
+	"download_dir",
+	"http_timeout",
+	"proxy",
+	"log_level",
+}
+
+const DefaultRepositoryUrl = "https://threatresponse-lime-modules.s3.amazonaws.com/"
+
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical"}
+
+// DefaultPath returns the per-user configuration file, usually
+// ~/.config/marsho/config.yaml
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "marsho", "config.yaml")
+}
+
+// Path returns the configuration file to load, flagPath when set, then
+// MARSHO_CONFIG and finally the default path
+func Path(flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if envPath := os.Getenv("MARSHO_CONFIG"); envPath != "" {
+		return envPath
+	}
+	return DefaultPath()
+}
+
+// Default returns the built in configuration
+func Default() Config {
+	conf := Config{
+		Repositories: []Repository{{Name: "public", Url: DefaultRepositoryUrl}},
+		HTTPTimeout:  "60s",
+		LogLevel:     "info",
+		origins:      map[string]string{},
+	}
+	for _, key := range Keys {
+		conf.origins[key] = "default"
+	}
+	return conf
+}
+
+// Load returns the default configuration overlaid with the configuration
+// file at path and MARSHO_* environment variables, a missing file is
+// treated as empty
+func Load(path string) (Config, error) {
+	conf := Default()
+
+	if path != "" {
+		err := conf.loadFile(path)
+		if err != nil {
+			return conf, err
+		}
+	}
+
+	for _, key := range Keys {
+		envKey := EnvKey(key)
+		if value, ok := os.LookupEnv(envKey); ok {
+			err := conf.Set(key, value, "env "+envKey)
+			if err != nil {
+				return conf, err
+			}
+		}
+	}
+
+	return conf, nil
+}
+
+// EnvKey returns the environment variable overriding key
+func EnvKey(key string) string {
+	return "MARSHO_" + strings.ToUpper(key)
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var fileConf Config
+	err = yaml.UnmarshalStrict(data, &fileConf)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error parsing %s: %s", path, err))
+	}
+
+	origin := "file " + path
+	if fileConf.Repositories != nil {
+		if len(fileConf.Repositories) == 0 {
+			return errors.New(fmt.Sprintf("error parsing %s: repositories is empty", path))
+		}
+		for i, repo := range fileConf.Repositories {
+			if repo.Url == "" {
+				return errors.New(
+					fmt.Sprintf("error parsing %s: repository %d has no url", path, i+1),
+				)
+			}
+		}
+		c.Repositories = fileConf.Repositories
+		c.origins["repositories"] = origin
+	}
+	if fileConf.TrustedFingerprints != nil {
+		c.TrustedFingerprints = fileConf.TrustedFingerprints
+		c.origins["trusted_fingerprints"] = origin
+	}
+	if fileConf.AllowWeakCrypto {
+		c.AllowWeakCrypto = true
+		c.origins["allow_weak_crypto"] = origin
+	}
+	if fileConf.AllowExpiredKeys {
+		c.AllowExpiredKeys = true
+		c.origins["allow_expired_keys"] = origin
+	}
+
+	for key, value := range map[string]string{
+		"keyring":          fileConf.Keyring,
+		"trust_store":      fileConf.TrustStore,
+		"max_metadata_age": fileConf.MaxMetadataAge,
+		"download_dir":     fileConf.DownloadDir,
+		"http_timeout":     fileConf.HTTPTimeout,
+		"proxy":            fileConf.Proxy,
+		"log_level":        fileConf.LogLevel,
+	} {
+		if value == "" {
+			continue
+		}
+		err = c.Set(key, value, origin)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error parsing %s: %s", path, err))
+		}
+	}
+
+	return nil
+}
+
+// Set overrides key with value parsed from a string, lists are comma
+// separated, origin records where the value came from
+func (c *Config) Set(key string, value string, origin string) error {
+	switch key {
+	case "repositories":
+		repoUrls := splitList(value)
+		if len(repoUrls) == 0 {
+			return errors.New("repositories must list at least one url")
+		}
+		c.Repositories = nil
+		for _, repoUrl := range repoUrls {
+			c.Repositories = append(c.Repositories, Repository{Url: repoUrl})
+		}
+	case "keyring":
+		c.Keyring = value
+	case "trust_store":
+		c.TrustStore = value
+	case "trusted_fingerprints":
+		c.TrustedFingerprints = splitList(value)
+	case "allow_weak_crypto":
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid allow_weak_crypto %s, expected true or false", value))
+		}
+		c.AllowWeakCrypto = allow
+	case "allow_expired_keys":
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid allow_expired_keys %s, expected true or false", value))
+		}
+		c.AllowExpiredKeys = allow
+	case "max_metadata_age":
+		if value != "" {
+			_, err := time.ParseDuration(value)
+			if err != nil {
+				return errors.New(fmt.Sprintf("invalid max_metadata_age %s: %s", value, err))
+			}
+		}
+		c.MaxMetadataAge = value
+	case "download_dir":
+		c.DownloadDir = value
+	case "http_timeout":
+		_, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid http_timeout %s: %s", value, err))
+		}
+		c.HTTPTimeout = value
+	case "proxy":
+		if value != "" {
+			_, err := url.Parse(value)
+			if err != nil {
+				return errors.New(fmt.Sprintf("invalid proxy %s: %s", value, err))
+			}
+		}
+		c.Proxy = value
+	case "log_level":
+		value = strings.ToLower(value)
+		if !contains(logLevels, value) {
+			return errors.New(fmt.Sprintf(
+				"invalid log_level %s, expected one of %s", value, strings.Join(logLevels, ", "),
+			))
+		}
+		c.LogLevel = value
+	default:
+		return errors.New(fmt.Sprintf("unknown configuration key %s", key))
+	}
+
+	if c.origins == nil {
+		c.origins = map[string]string{}
+	}
+	c.origins[key] = origin
+	return nil
+}
+
+// Origin describes where the effective value of key came from
+func (c *Config) Origin(key string) string {
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return "default"
+}
+
+// Timeout returns the parsed http_timeout
+func (c *Config) Timeout() time.Duration {
+	timeout, err := time.ParseDuration(c.HTTPTimeout)
+	if err != nil {
+		return 60 * time.Second
+	}
+	return timeout
+}
+
+// MetadataAge returns the parsed max_metadata_age, zero when unset
+func (c *Config) MetadataAge() time.Duration {
+	age, err := time.ParseDuration(c.MaxMetadataAge)
+	if err != nil {
+		return 0
+	}
+	return age
+}
+
+// Settings returns every effective setting in display order
+func (c *Config) Settings() []Setting {
+	var settings []Setting
+	for _, key := range Keys {
+		var value string
+		switch key {
+		case "repositories":
+			var repos []string
+			for _, repo := range c.Repositories {
+				if repo.Name != "" {
+					repos = append(repos, fmt.Sprintf("%s=%s", repo.Name, repo.Url))
+				} else {
+					repos = append(repos, repo.Url)
+				}
+			}
+			value = strings.Join(repos, ", ")
+		case "keyring":
+			value = c.Keyring
+		case "trust_store":
+			value = c.TrustStore
+		case "trusted_fingerprints":
+			value = strings.Join(c.TrustedFingerprints, ", ")
+		case "allow_weak_crypto":
+			value = strconv.FormatBool(c.AllowWeakCrypto)
+		case "allow_expired_keys":
+			value = strconv.FormatBool(c.AllowExpiredKeys)
+		case "max_metadata_age":
+			value = c.MaxMetadataAge
+		case "download_dir":
+			value = c.DownloadDir
+		case "http_timeout":
+			value = c.HTTPTimeout
+		case "proxy":
+			value = c.Proxy
+		case "log_level":
+			value = c.LogLevel
+		}
+		settings = append(settings, Setting{key, value, c.Origin(key)})
+	}
+	return settings
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
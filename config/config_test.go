@@ -0,0 +1,107 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type loadTest struct {
+	data  string
+	repos int
+	valid bool
+}
+
+var loadtests = []loadTest{
+	{"", 1, true},
+	{"repositories:\n  - name: internal\n    url: https://mirror.example/\n  - url: https://public.example/\n", 2, true},
+	{"repositories:\n  - name: internal\n", 0, false},
+	{"repos:\n  - url: https://mirror.example/\n", 0, false},
+	{"http_timeout: soon\n", 0, false},
+	{"log_level: chatty\n", 0, false},
+	{"allow_weak_crypto: true\nallow_expired_keys: false\n", 1, true},
+	{"allow_weak_crypto: sometimes\n", 0, false},
+	{"max_metadata_age: 720h\n", 1, true},
+	{"max_metadata_age: a month\n", 0, false},
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marsho-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	for _, input := range loadtests {
+		ioutil.WriteFile(path, []byte(input.data), 0600)
+		conf, err := Load(path)
+		if (err == nil) != input.valid {
+			t.Error("For\n", input.data, "expected valid?", input.valid, "got err", err)
+			continue
+		}
+		if input.valid && len(conf.Repositories) != input.repos {
+			t.Error("For\n", input.data, "expected", input.repos, "repositories got", len(conf.Repositories))
+		}
+	}
+
+	conf, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil || len(conf.Repositories) != 1 || conf.Repositories[0].Url != DefaultRepositoryUrl {
+		t.Error("expected default configuration for missing file got", conf, err)
+	}
+}
+
+type originTest struct {
+	key    string
+	value  string
+	origin string
+}
+
+func TestOrigins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marsho-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("http_timeout: 10s\nlog_level: debug\n"), 0600)
+
+	os.Setenv("MARSHO_LOG_LEVEL", "error")
+	defer os.Unsetenv("MARSHO_LOG_LEVEL")
+	os.Setenv("MARSHO_ALLOW_EXPIRED_KEYS", "true")
+	defer os.Unsetenv("MARSHO_ALLOW_EXPIRED_KEYS")
+
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conf.Set("repositories", "https://a.example/, https://b.example/", "flag -repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origintests := []originTest{
+		{"http_timeout", "10s", "file " + path},
+		{"log_level", "error", "env MARSHO_LOG_LEVEL"},
+		{"repositories", "https://a.example/, https://b.example/", "flag -repo"},
+		{"proxy", "", "default"},
+		{"allow_expired_keys", "true", "env MARSHO_ALLOW_EXPIRED_KEYS"},
+		{"allow_weak_crypto", "false", "default"},
+	}
+
+	settings := map[string]Setting{}
+	for _, setting := range conf.Settings() {
+		settings[setting.Key] = setting
+	}
+	for _, input := range origintests {
+		setting := settings[input.key]
+		if setting.Value != input.value || setting.Origin != input.origin {
+			t.Error(
+				"For", input.key,
+				"expected", input.value, "from", input.origin,
+				"got", setting.Value, "from", setting.Origin,
+			)
+		}
+	}
+}
@@ -29,6 +29,7 @@ func main() {
 	}
 
 	InitLogging(verbose)
+	meta.Verbose = verbose
 
 	c := &cli.CLI{
 		Args:       args,
@@ -37,5 +38,10 @@ func main() {
 		HelpWriter: os.Stdout,
 	}
 
-	c.Run()
+	exitStatus, err := c.Run()
+	if err != nil {
+		log.Critical(err)
+	}
+
+	os.Exit(exitStatus)
 }
@@ -0,0 +1,367 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildOptions control the metadata generated by Build, the repository is
+// left unsigned when SigningKey is empty
+type BuildOptions struct {
+	Packager   string
+	Platform   string
+	SigningKey string
+	Passphrase string
+}
+
+// primaryXML and repomdXML name the document roots used when writing
+// Manifest and RepoMetadata back out
+type primaryXML struct {
+	XMLName xml.Name `xml:"modules"`
+	Modules []Module `xml:"module"`
+}
+
+type repomdXML struct {
+	XMLName  xml.Name           `xml:"metadata"`
+	Revision string             `xml:"revision"`
+	Data     []ManifestMetadata `xml:"data"`
+}
+
+// Build scans dir/modules for LiME kernel modules and writes the repodata
+// for them, signing the metadata and every module when a key is supplied
+func Build(dir string, opts BuildOptions) (Manifest, error) {
+	layout := DefaultRepository()
+	layout.BaseUrl = dir
+
+	var signer *openpgp.Entity
+	if opts.SigningKey != "" {
+		var err error
+		signer, err = readSigningKey(opts.SigningKey, opts.Passphrase)
+		if err != nil {
+			return Manifest{}, errors.New(fmt.Sprintf("error reading signing key: %s", err))
+		}
+	}
+
+	modules, err := scanModules(dir, opts)
+	if err != nil {
+		return Manifest{}, err
+	}
+	log.Info(fmt.Sprintf("found %d modules in %s", len(modules), dir))
+
+	if signer != nil {
+		for i, mod := range modules {
+			modules[i].Signature.Href = mod.Location.Href + ".sig"
+			err = signFile(signer, dir, mod.Location.Href, modules[i].Signature.Href)
+			if err != nil {
+				return Manifest{}, err
+			}
+		}
+	}
+
+	data, err := xml.MarshalIndent(primaryXML{Modules: modules}, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	data = append([]byte(xml.Header), data...)
+
+	var gzBuf bytes.Buffer
+	writer := gzip.NewWriter(&gzBuf)
+	writer.Write(data)
+	err = writer.Close()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	revision := nextRevision(dir, layout)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	openSum := sha256Checksum(data)
+	primary := ManifestMetadata{
+		RepoType:     primaryData,
+		Checksum:     sha256Checksum(gzBuf.Bytes()),
+		OpenChecksum: openSum,
+		Location: Location{
+			fmt.Sprintf("%s%s-primary.xml.gz", layout.metaDir, openSum),
+		},
+		Timestamp: now,
+		Size:      gzBuf.Len(),
+		OpenSize:  len(data),
+	}
+	err = writeMirrorFile(dir, primary.Location.Href, gzBuf.Bytes())
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	repo := repomdXML{Revision: revision, Data: []ManifestMetadata{primary}}
+	repomd, err := xml.MarshalIndent(repo, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	repomd = append([]byte(xml.Header), repomd...)
+
+	// the signature and signing key are written before repomd.xml so
+	// clients never see new metadata without its signature
+	if signer != nil {
+		var sig bytes.Buffer
+		err = openpgp.DetachSign(&sig, signer, bytes.NewReader(repomd), nil)
+		if err != nil {
+			return Manifest{}, errors.New(fmt.Sprintf("error signing metadata: %s", err))
+		}
+		err = writeMirrorFile(dir, layout.metaDir+layout.repoMetaSig, sig.Bytes())
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		var pubKey bytes.Buffer
+		armorWriter, err := armor.Encode(&pubKey, openpgp.PublicKeyType, nil)
+		if err != nil {
+			return Manifest{}, err
+		}
+		err = signer.Serialize(armorWriter)
+		armorWriter.Close()
+		if err != nil {
+			return Manifest{}, err
+		}
+		err = writeMirrorFile(dir, layout.signingKey, pubKey.Bytes())
+		if err != nil {
+			return Manifest{}, err
+		}
+	} else {
+		// a signature left from a signed build would no longer match
+		for _, href := range []string{layout.metaDir + layout.repoMetaSig, layout.signingKey} {
+			err = removeRepoFile(dir, href)
+			if err != nil {
+				return Manifest{}, err
+			}
+		}
+	}
+	err = writeMirrorFile(dir, layout.metaDir+layout.repoMeta, repomd)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return Manifest{Modules: modules}, nil
+}
+
+// nextRevision returns the revision for new metadata in dir, the current
+// time unless an earlier build already used it so clients recording the
+// highest revision seen never see it go backwards or repeat
+func nextRevision(dir string, layout Repository) string {
+	revision := time.Now().Unix()
+	data, err := ioutil.ReadFile(filepath.Join(dir, layout.metaDir, layout.repoMeta))
+	if err != nil {
+		return strconv.FormatInt(revision, 10)
+	}
+	var previous repomdXML
+	err = xml.Unmarshal(data, &previous)
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to read the previous revision in %s: %s", dir, err))
+		return strconv.FormatInt(revision, 10)
+	}
+	last, err := strconv.ParseInt(previous.Revision, 10, 64)
+	if err == nil && last >= revision {
+		revision = last + 1
+	}
+	return strconv.FormatInt(revision, 10)
+}
+
+// removeRepoFile deletes dir/href if it exists
+func removeRepoFile(dir string, href string) error {
+	path, err := mirrorPath(dir, href)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New(fmt.Sprintf("unable to remove %s: %s", path, err))
+	}
+	return nil
+}
+
+// scanModules describes every .ko file below dir/modules
+func scanModules(dir string, opts BuildOptions) ([]Module, error) {
+	var modules []Module
+	modDir := filepath.Join(dir, "modules")
+
+	err := filepath.Walk(modDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".ko" {
+			return nil
+		}
+
+		mod, err := describeModule(path, opts)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error reading module %s: %s", path, err))
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		mod.Location.Href = filepath.ToSlash(rel)
+		modules = append(modules, mod)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Location.Href < modules[j].Location.Href
+	})
+	return modules, nil
+}
+
+// describeModule reads the kernel version and architecture of a module
+// from its ELF header and .modinfo section
+func describeModule(path string, opts BuildOptions) (Module, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Module{}, err
+	}
+
+	elfFile, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return Module{}, err
+	}
+	defer elfFile.Close()
+
+	info, err := modinfo(elfFile)
+	if err != nil {
+		return Module{}, err
+	}
+	vermagic := strings.Fields(info["vermagic"])
+	if len(vermagic) == 0 {
+		return Module{}, errors.New("module has no vermagic")
+	}
+
+	modType := info["name"]
+	if modType == "" {
+		modType = "lime"
+	}
+
+	return Module{
+		ModuleType: modType,
+		Name:       filepath.Base(path),
+		Arch:       elfArch(elfFile),
+		Checksum:   sha256Checksum(data),
+		Version:    vermagic[0],
+		Packager:   opts.Packager,
+		Platform:   opts.Platform,
+	}, nil
+}
+
+// modinfo parses the NUL separated key=value pairs of the .modinfo section
+func modinfo(elfFile *elf.File) (map[string]string, error) {
+	section := elfFile.Section(".modinfo")
+	if section == nil {
+		return nil, errors.New("module has no .modinfo section")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, field := range bytes.Split(data, []byte{0}) {
+		parts := strings.SplitN(string(field), "=", 2)
+		if len(parts) == 2 {
+			info[parts[0]] = parts[1]
+		}
+	}
+	return info, nil
+}
+
+// elfArch maps an ELF machine to the architecture names used by kernels
+func elfArch(elfFile *elf.File) string {
+	switch elfFile.Machine {
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_386:
+		return "i686"
+	case elf.EM_AARCH64:
+		return "aarch64"
+	case elf.EM_ARM:
+		return "armv7l"
+	case elf.EM_PPC64:
+		if elfFile.ByteOrder == binary.LittleEndian {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_S390:
+		return "s390x"
+	}
+	return strings.ToLower(strings.TrimPrefix(elfFile.Machine.String(), "EM_"))
+}
+
+// readSigningKey loads the first secret key from an armored keyring file,
+// decrypting it with passphrase when necessary
+func readSigningKey(path string, passphrase string) (*openpgp.Entity, error) {
+	keyFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer keyFile.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			err = entity.PrivateKey.Decrypt([]byte(passphrase))
+			if err != nil {
+				return nil, errors.New("unable to decrypt secret key, check the passphrase")
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				err = subkey.PrivateKey.Decrypt([]byte(passphrase))
+				if err != nil {
+					return nil, errors.New("unable to decrypt secret subkey, check the passphrase")
+				}
+			}
+		}
+		return entity, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("no secret key found in %s", path))
+}
+
+// signFile writes a detached binary signature of dir/href to dir/sigHref
+func signFile(signer *openpgp.Entity, dir string, href string, sigHref string) error {
+	path, err := mirrorPath(dir, href)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var sig bytes.Buffer
+	err = openpgp.DetachSign(&sig, signer, file, nil)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error signing %s: %s", href, err))
+	}
+	return writeMirrorFile(dir, sigHref, sig.Bytes())
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/xml"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+var fixtureModule = "lime-4.2.0-17-generic.ko"
+
+// buildFixture copies the fixture module into a new repository directory
+func buildFixture(t *testing.T) string {
+	data, err := ioutil.ReadFile(filepath.Join(fixtureDir, "modules", fixtureModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestRepo(t, map[string][]byte{
+		"/modules/" + fixtureModule: data,
+	})
+}
+
+func TestBuild(t *testing.T) {
+	dir := buildFixture(t)
+	defer os.RemoveAll(dir)
+
+	manifest, err := Build(dir, BuildOptions{Packager: "marsho", Platform: "linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := DefaultRepository()
+	r.BaseUrl = dir + "/"
+	r.SkipGPGVerify = true
+	listed, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Modules) != 1 || len(manifest.Modules) != 1 {
+		t.Fatal("expected 1 module got", len(listed.Modules), "and", len(manifest.Modules))
+	}
+
+	mod := listed.Modules[0]
+	expected := Module{
+		ModuleType: "lime",
+		Name:       fixtureModule,
+		Arch:       "x86_64",
+		Checksum:   manifest.Modules[0].Checksum,
+		Version:    "4.2.0-17-generic",
+		Packager:   "marsho",
+		Location:   Location{"modules/" + fixtureModule},
+		Platform:   "linux",
+		Source:     dir + "/",
+	}
+	if mod != expected {
+		t.Error("expected module", expected, "got", mod)
+	}
+}
+
+// signedBuildFixture builds a repository signed with a new key
+func signedBuildFixture(t *testing.T) (string, *openpgp.Entity, Manifest) {
+	dir := buildFixture(t)
+
+	entity, err := openpgp.NewEntity("marsho test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret bytes.Buffer
+	armorWriter, _ := armor.Encode(&secret, openpgp.PrivateKeyType, nil)
+	entity.SerializePrivate(armorWriter, nil)
+	armorWriter.Close()
+	keyPath := filepath.Join(dir, "secret.asc")
+	ioutil.WriteFile(keyPath, secret.Bytes(), 0600)
+
+	manifest, err := Build(dir, BuildOptions{SigningKey: keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, entity, manifest
+}
+
+func TestBuildSigned(t *testing.T) {
+	dir, entity, manifest := signedBuildFixture(t)
+	defer os.RemoveAll(dir)
+
+	keyring := &gpgKeyring{"gpg", "", &openpgp.EntityList{entity}}
+	repomd, _ := os.Open(filepath.Join(dir, "repodata", "repomd.xml"))
+	defer repomd.Close()
+	sig, _ := os.Open(filepath.Join(dir, "repodata", "repomd.xml.sig"))
+	defer sig.Close()
+	_, err := keyring.verifyDetachedSig(repomd, sig, Policy{})
+	if err != nil {
+		t.Error("expected valid metadata signature got", err)
+	}
+
+	keyFile, _ := os.Open(filepath.Join(dir, "REPO_SIGNING_KEY.asc"))
+	defer keyFile.Close()
+	repoKey, err := readKey(keyFile)
+	if err != nil || !keyring.contains(repoKey) {
+		t.Error("expected exported signing key got", err)
+	}
+
+	// modules must download and verify against the signing key
+	r := DefaultRepository()
+	r.BaseUrl = dir + "/"
+	r.keyring = keyring
+	_, err = r.downloadTo(manifest.Modules[0], filepath.Join(dir, "download.ko"))
+	if err != nil {
+		t.Error("expected signed module to verify got", err)
+	}
+
+	// an unsigned rebuild within the same second must not keep the stale
+	// signature or reuse the revision
+	previous := repomdRevision(t, dir)
+	_, err = Build(dir, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"repodata/repomd.xml.sig", "REPO_SIGNING_KEY.asc"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Error("expected unsigned build to remove", name)
+		}
+	}
+	if revision := repomdRevision(t, dir); revision <= previous {
+		t.Error("expected revision above", previous, "got", revision)
+	}
+}
+
+// repomdRevision reads the revision of the metadata built in dir
+func repomdRevision(t *testing.T, dir string) int64 {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repo repomdXML
+	xml.Unmarshal(data, &repo)
+	revision, err := strconv.ParseInt(repo.Revision, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revision
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	cacheStateFile    = "state.json"
+	manifestCacheFile = "primary.xml"
+)
+
+// cache stores verified repository metadata and the decompressed manifest
+// for a single repository under <CacheDir>/<repo-hash>/
+type cache struct {
+	dir string
+}
+
+// cacheState records the validators of the cached repomd.xml and the
+// repository revision the cached manifest belongs to
+type cacheState struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Revision     string `json:"revision"`
+}
+
+// DefaultCacheDir returns the per-user marsho cache directory, usually
+// ~/.cache/marsho, or an empty string if it cannot be determined
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "marsho")
+}
+
+func newCache(root string, baseUrl string) *cache {
+	sum := sha256.Sum256([]byte(baseUrl))
+	return &cache{
+		filepath.Join(root, hex.EncodeToString(sum[:8])),
+	}
+}
+
+func (c *cache) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(c.dir, name))
+}
+
+// write atomically replaces a cached file so an interrupted run never
+// leaves a partially written manifest behind
+func (c *cache) write(name string, data []byte) error {
+	err := os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, name), data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// store writes a cached file, failures only disable caching so they are
+// logged rather than returned
+func (c *cache) store(name string, data []byte) {
+	err := c.write(name, data)
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to write %s to cache: %s", name, err))
+	}
+}
+
+// remove deletes a cached file so it is fetched again
+func (c *cache) remove(name string) {
+	err := os.Remove(filepath.Join(c.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warning(fmt.Sprintf("unable to remove %s from cache: %s", name, err))
+	}
+}
+
+func (c *cache) state() cacheState {
+	var state cacheState
+	data, err := c.read(cacheStateFile)
+	if err != nil {
+		return state
+	}
+	json.Unmarshal(data, &state)
+	return state
+}
+
+func (c *cache) saveState(state cacheState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to encode cache state: %s", err))
+		return
+	}
+	c.store(cacheStateFile, data)
+}
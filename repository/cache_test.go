@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRepoFiles builds a minimal unsigned repository around manifestData
+func testRepoFiles(t *testing.T, manifestData []byte) map[string][]byte {
+	var gzBuf bytes.Buffer
+	writer := gzip.NewWriter(&gzBuf)
+	writer.Write(manifestData)
+	writer.Close()
+
+	gzSum := sha256.Sum256(gzBuf.Bytes())
+	openSum := sha256.Sum256(manifestData)
+	manifestHref := fmt.Sprintf("repodata/%s-primary.xml.gz", hex.EncodeToString(openSum[:]))
+
+	repomd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<metadata>
+  <revision>1487818901</revision>
+  <data type="primary">
+    <checksum>%s</checksum>
+    <open_checksum>%s</open_checksum>
+    <location href="%s"/>
+    <timestamp>1487818901</timestamp>
+    <size>%d</size>
+    <open_size>%d</open_size>
+  </data>
+</metadata>`,
+		hex.EncodeToString(gzSum[:]), hex.EncodeToString(openSum[:]),
+		manifestHref, gzBuf.Len(), len(manifestData),
+	)
+
+	return map[string][]byte{
+		"/repodata/repomd.xml": []byte(repomd),
+		"/" + manifestHref:     gzBuf.Bytes(),
+	}
+}
+
+func TestCachedManifest(t *testing.T) {
+	files := testRepoFiles(t, unzippedManifestData)
+	requests := map[string]int{}
+	notModified := 0
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			requests[req.URL.Path]++
+			data, ok := files[req.URL.Path]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			if req.Header.Get("If-None-Match") == `"rev1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"rev1"`)
+			w.Write(data)
+		},
+	))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "marsho-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = server.URL + "/"
+	r.SkipGPGVerify = true
+	r.CacheDir = cacheDir
+
+	for i := 0; i < 2; i++ {
+		manifest, err := r.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Modules) != 1 {
+			t.Error("For run", i, "expected 1 module got", len(manifest.Modules))
+		}
+	}
+
+	if notModified != 1 {
+		t.Error("expected 1 conditional metadata request got", notModified)
+	}
+	for path, count := range requests {
+		if path != "/repodata/repomd.xml" && count != 1 {
+			t.Error("For", path, "expected 1 request got", count)
+		}
+	}
+
+	// offline mode must be served entirely from the cache
+	server.Close()
+	r.Offline = true
+	manifest, err := r.List()
+	if err != nil || len(manifest.Modules) != 1 {
+		t.Error("offline list expected 1 module got", len(manifest.Modules), "with err", err)
+	}
+
+	r.CacheDir = cacheDir + "-missing"
+	_, err = r.List()
+	if err == nil {
+		t.Error("offline list expected error without cached metadata")
+	}
+}
+
+func TestCacheUnverifiedThenVerified(t *testing.T) {
+	dir, entity, _ := signedBuildFixture(t)
+	defer os.RemoveAll(dir)
+
+	// files are read on every request so a rebuild is served at once, the
+	// ETag follows their content
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			data, err := ioutil.ReadFile(dir + req.URL.Path)
+			if err != nil {
+				http.NotFound(w, req)
+				return
+			}
+			sum := sha256.Sum256(data)
+			etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+			if req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write(data)
+		},
+	))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "marsho-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = server.URL + "/"
+	r.CacheDir = cacheDir
+	r.StateDir = ""
+	r.Fingerprints = []string{(&gpgKey{entity}).fingerprint()}
+	_, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the repository is re-signed with new metadata, an unverified run
+	// caches it and a verified run must not pair it with the old signature
+	_, err = Build(dir, BuildOptions{Packager: "rebuilt", SigningKey: filepath.Join(dir, "secret.asc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SkipGPGVerify = true
+	_, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SkipGPGVerify = false
+	manifest, err := r.List()
+	if err != nil || manifest.Modules[0].Packager != "rebuilt" {
+		t.Error("expected verified rebuilt metadata got", err)
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Checksum is a <checksum> or <open_checksum> element, the algorithm is
+// given by its type attribute and defaults to sha256 when absent
+type Checksum struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// sha256Checksum returns the sha256 Checksum of data
+func sha256Checksum(data []byte) Checksum {
+	sum := sha256.Sum256(data)
+	return Checksum{"sha256", hex.EncodeToString(sum[:])}
+}
+
+func (c Checksum) String() string {
+	return c.Value
+}
+
+// Algorithm returns the normalized checksum type, yum uses sha for sha1
+func (c Checksum) Algorithm() string {
+	algorithm := strings.ToLower(strings.TrimSpace(c.Type))
+	switch algorithm {
+	case "":
+		return "sha256"
+	case "sha":
+		return "sha1"
+	}
+	return algorithm
+}
+
+// newHash returns a hash for the checksum type, sha1 is accepted with a
+// warning and unknown types are an error rather than a certain mismatch
+func (c Checksum) newHash() (hash.Hash, error) {
+	switch c.Algorithm() {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha1":
+		log.Warning(fmt.Sprintf("checksum %s uses weak sha1", c.Value))
+		return sha1.New(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported checksum type %s", c.Type))
+}
+
+// matches reports whether the sum of a hash from newHash equals the checksum
+func (c Checksum) matches(sum []byte) bool {
+	return strings.EqualFold(hex.EncodeToString(sum), strings.TrimSpace(c.Value))
+}
+
+// verify returns whether data matches the checksum and the calculated sum
+func (c Checksum) verify(data []byte) (bool, string, error) {
+	h, err := c.newHash()
+	if err != nil {
+		return false, "", err
+	}
+	h.Write(data)
+	sum := h.Sum(nil)
+	return c.matches(sum), hex.EncodeToString(sum), nil
+}
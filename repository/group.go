@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Group searches several repositories in priority order, manifests are
+// merged with each module annotated with its source repository and
+// downloads fall back to the next repository listing the same module
+type Group struct {
+	Repositories []*Repository
+}
+
+func (g *Group) List() (Manifest, error) {
+	manifests, err := g.load()
+	if err != nil {
+		return Manifest{}, err
+	}
+	return merge(manifests), nil
+}
+
+// Find returns the modules of every repository matching f, failing with the
+// closest modules when none match
+func (g *Group) Find(f Filter) ([]Module, error) {
+	manifests, err := g.load()
+	if err != nil {
+		return nil, err
+	}
+	merged := merge(manifests)
+	return merged.find(f)
+}
+
+// Filter returns the modules of every repository matching f
+func (g *Group) Filter(f Filter) ([]Module, error) {
+	manifests, err := g.load()
+	if err != nil {
+		return nil, err
+	}
+	merged := merge(manifests)
+	return merged.Filter(f)
+}
+
+// Get downloads the module matching kernVer, choose is consulted when more
+// than one module matches and may be nil to refuse ambiguous versions
+func (g *Group) Get(kernVer string, choose Selector) (FetchResult, error) {
+	manifests, err := g.load()
+	if err != nil {
+		return FetchResult{}, err
+	}
+	merged := unique(merge(manifests))
+	modules, err := merged.find(Filter{Version: kernVer})
+	if err != nil {
+		return FetchResult{}, err
+	}
+
+	mod := modules[0]
+	if len(modules) > 1 {
+		if choose == nil {
+			var names []string
+			for _, mod := range modules {
+				names = append(names, mod.Name)
+			}
+			return FetchResult{}, errors.New(fmt.Sprintf(
+				"multiple matches for: %s (%s), use -all to fetch every match or -first or -latest to pick one",
+				kernVer, strings.Join(names, ", "),
+			))
+		}
+		mod, err = choose(modules)
+		if err != nil {
+			return FetchResult{}, err
+		}
+	}
+	log.Debug(fmt.Sprintf("found module matching %s: %s", kernVer, mod.Name))
+
+	result := g.fetch(manifests, mod)
+	return result, result.Err
+}
+
+// GetAll downloads and verifies every module matching kernVer using at most
+// workers concurrent downloads, results are returned in manifest order
+func (g *Group) GetAll(kernVer string, workers int) ([]FetchResult, error) {
+	manifests, err := g.load()
+	if err != nil {
+		return nil, err
+	}
+	merged := unique(merge(manifests))
+	modules, err := merged.find(Filter{Version: kernVer})
+	if err != nil {
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("found %d modules matching: %s", len(modules), kernVer))
+
+	return g.fetchAll(manifests, modules, workers), nil
+}
+
+// Sources names the repositories in the group in priority order
+func (g *Group) Sources() []string {
+	var sources []string
+	for _, r := range g.Repositories {
+		sources = append(sources, r.Source())
+	}
+	return sources
+}
+
+// load fetches the manifest of every repository, repositories that cannot
+// be loaded are skipped with a warning unless none of them can be
+func (g *Group) load() ([]Manifest, error) {
+	manifests := make([]Manifest, len(g.Repositories))
+	loaded := 0
+	var lastErr error
+	for i, r := range g.Repositories {
+		manifest, err := r.manifest()
+		if err != nil {
+			if len(g.Repositories) > 1 {
+				log.Warning(fmt.Sprintf("skipping repository %s: %s", r.Source(), err))
+			}
+			lastErr = err
+			continue
+		}
+		manifests[i] = manifest
+		loaded++
+	}
+
+	if loaded == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no repositories configured")
+		}
+		return nil, lastErr
+	}
+	return manifests, nil
+}
+
+func (g *Group) fetchAll(manifests []Manifest, modules []Module, workers int) []FetchResult {
+	return forEach(modules, workers, func(mod Module) FetchResult {
+		result := g.fetch(manifests, mod)
+		if result.Err != nil {
+			log.Error(result.Err)
+		} else {
+			log.Info(fmt.Sprintf("module downloaded to %s", result.Path))
+		}
+		return result
+	})
+}
+
+// fetch downloads mod from the first repository that lists it and falls
+// back to lower priority repositories when a download fails verification,
+// the result holds the module as listed by the repository it came from
+func (g *Group) fetch(manifests []Manifest, mod Module) FetchResult {
+	var failures []string
+	for i, r := range g.Repositories {
+		candidate, ok := manifests[i].lookup(mod.Name)
+		if !ok {
+			continue
+		}
+
+		localPath, err := r.download(candidate)
+		if err == nil {
+			return FetchResult{Module: candidate, Path: localPath, Verified: r.verification()}
+		}
+		if len(g.Repositories) == 1 {
+			return FetchResult{Module: mod, Err: err}
+		}
+		log.Warning(fmt.Sprintf("unable to fetch %s from %s: %s", mod.Name, r.Source(), err))
+		failures = append(failures, fmt.Sprintf("%s: %s", r.Source(), err))
+	}
+
+	if len(failures) == 0 {
+		return FetchResult{Module: mod, Err: errors.New(
+			fmt.Sprintf("module %s not found in any repository", mod.Name),
+		)}
+	}
+	return FetchResult{Module: mod, Err: errors.New(fmt.Sprintf(
+		"unable to fetch %s from any repository (%s)", mod.Name, strings.Join(failures, "; "),
+	))}
+}
+
+// forEach runs fn for every module using at most workers goroutines and
+// returns the results in module order
+func forEach(modules []Module, workers int, fn func(Module) FetchResult) []FetchResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]FetchResult, len(modules))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fn(modules[i])
+			}
+		}()
+	}
+
+	for i := range modules {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// merge concatenates manifests in priority order
+func merge(manifests []Manifest) Manifest {
+	var merged Manifest
+	for _, manifest := range manifests {
+		merged.Modules = append(merged.Modules, manifest.Modules...)
+	}
+	return merged
+}
+
+// unique drops modules already listed by a higher priority repository
+func unique(manifest Manifest) Manifest {
+	var deduped Manifest
+	seen := map[string]bool{}
+	for _, mod := range manifest.Modules {
+		if seen[mod.Name] {
+			continue
+		}
+		seen[mod.Name] = true
+		deduped.Modules = append(deduped.Modules, mod)
+	}
+	return deduped
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var groupModule = `
+  <module type="lime">
+    <name>%[1]s</name>
+    <arch>x86_64</arch>
+    <checksum>%[2]s</checksum>
+    <version>%[3]s</version>
+    <packager>lime-compiler</packager>
+    <location href="modules/%[1]s"/>
+    <platform>linux</platform>
+  </module>`
+
+// groupRepo writes a local repository listing the named modules, each with
+// the given content
+func groupRepo(t *testing.T, modules map[string]string) string {
+	files := map[string][]byte{}
+	var entries []string
+	for name, content := range modules {
+		sum := sha256.Sum256([]byte("lime kernel module"))
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "lime-"), ".ko")
+		entries = append(entries, fmt.Sprintf(groupModule, name, hex.EncodeToString(sum[:]), version))
+		files["/modules/"+name] = []byte(content)
+	}
+	manifest := fmt.Sprintf("<modules>%s\n</modules>", strings.Join(entries, ""))
+	for path, data := range testRepoFiles(t, []byte(manifest)) {
+		files[path] = data
+	}
+	return writeTestRepo(t, files)
+}
+
+func TestGroup(t *testing.T) {
+	// the mirror carries a corrupt copy of 4.2.0 and lacks 4.4.0
+	mirror := groupRepo(t, map[string]string{
+		"lime-4.2.0.ko": "corrupt module",
+	})
+	defer os.RemoveAll(mirror)
+	public := groupRepo(t, map[string]string{
+		"lime-4.2.0.ko": "lime kernel module",
+		"lime-4.4.0.ko": "lime kernel module",
+	})
+	defer os.RemoveAll(public)
+
+	var repos []*Repository
+	for _, source := range [][2]string{
+		{"missing", mirror + "-missing/"},
+		{"mirror", mirror + "/"},
+		{"public", public + "/"},
+	} {
+		r := DefaultRepository()
+		r.Name = source[0]
+		r.BaseUrl = source[1]
+		r.SkipGPGVerify = true
+		repos = append(repos, &r)
+	}
+	g := Group{repos}
+
+	manifest, err := g.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]int{}
+	for _, mod := range manifest.Modules {
+		sources[mod.Source]++
+	}
+	if len(manifest.Modules) != 3 || sources["mirror"] != 1 || sources["public"] != 2 {
+		t.Error("expected 1 mirror and 2 public modules got", sources)
+	}
+
+	workDir, err := ioutil.TempDir("", "marsho-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	cwd, _ := os.Getwd()
+	os.Chdir(workDir)
+	defer os.Chdir(cwd)
+
+	_, err = g.Get("4.*", nil)
+	if err == nil || !strings.Contains(err.Error(), "lime-4.2.0.ko, lime-4.4.0.ko") ||
+		!strings.Contains(err.Error(), "-latest") {
+		t.Error("expected ambiguous match error naming the modules and options got", err)
+	}
+
+	results, err := g.GetAll("4.*", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected 2 results got", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Error("For", result.Module.Name, "expected fallback download got", result.Err)
+		}
+	}
+}
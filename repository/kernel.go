@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// compareVersions orders kernel version strings by comparing runs of digits
+// numerically and everything else lexically, it returns -1, 0 or 1
+func compareVersions(a string, b string) int {
+	aParts := versionParts(a)
+	bParts := versionParts(b)
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				return compareInts(aNum, bNum)
+			}
+		case aErr == nil:
+			// numeric components sort after alphabetic ones
+			return 1
+		case bErr == nil:
+			return -1
+		case aParts[i] != bParts[i]:
+			if aParts[i] < bParts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return compareInts(len(aParts), len(bParts))
+}
+
+func compareInts(a int, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// versionParts splits a version into alternating digit and letter runs,
+// separators such as '.', '-' and '_' are dropped
+func versionParts(version string) []string {
+	var parts []string
+	var current []rune
+	digits := false
+
+	for _, c := range version {
+		isDigit := unicode.IsDigit(c)
+		isLetter := unicode.IsLetter(c)
+		if !isDigit && !isLetter {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			continue
+		}
+		if len(current) > 0 && isDigit != digits {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		digits = isDigit
+		current = append(current, c)
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+
+	return parts
+}
+
+// KernelRelease is a kernel release string, as printed by uname -r, split
+// into its upstream version and the distribution specific build
+type KernelRelease struct {
+	Release string
+	Version []int
+	ABI     string
+	Distro  string
+	Flavour string
+	Arch    string
+}
+
+var (
+	upstreamRelease = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?(.*)$`)
+	archSuffix      = regexp.MustCompile(`\.(x86_64|i[3-6]86|aarch64|ppc64le|ppc64|s390x|armv7hl)$`)
+	amazonRelease   = regexp.MustCompile(`^-(.+)\.(amzn\d+)$`)
+	redhatRelease   = regexp.MustCompile(`^-(.+)\.(el\d+)(?:_\d+)?$`)
+	flavourRelease  = regexp.MustCompile(`^-([\d.]+)-([a-z0-9][a-z0-9-]*)$`)
+)
+
+// debianFlavours are the Debian kernel flavours, which name the
+// architecture, and the architecture they are built for
+var debianFlavours = map[string]string{
+	"amd64":       "x86_64",
+	"cloud-amd64": "x86_64",
+	"rt-amd64":    "x86_64",
+	"arm64":       "aarch64",
+	"cloud-arm64": "aarch64",
+	"rt-arm64":    "aarch64",
+	"686":         "i686",
+	"686-pae":     "i686",
+	"armmp":       "armv7hl",
+	"armmp-lpae":  "armv7hl",
+	"powerpc64le": "ppc64le",
+	"s390x":       "s390x",
+}
+
+// suseFlavours are kernel flavours only SUSE uses
+var suseFlavours = map[string]bool{
+	"default":  true,
+	"kvmsmall": true,
+	"64kb":     true,
+	"pae":      true,
+}
+
+// ParseKernelRelease splits release following the upstream, Ubuntu
+// (4.15.0-20-generic), Debian (4.19.0-8-amd64), SUSE (4.12.14-122.37-default),
+// RHEL and CentOS (3.10.0-957.el7.x86_64) and Amazon Linux
+// (4.14.177-139.253.amzn2.x86_64) conventions, unrecognized builds are kept
+// whole as the ABI of an upstream release
+func ParseKernelRelease(release string) KernelRelease {
+	k := KernelRelease{Release: release, Distro: "upstream"}
+	match := upstreamRelease.FindStringSubmatch(release)
+	if match == nil {
+		k.ABI = release
+		return k
+	}
+	for _, part := range match[1:4] {
+		if part != "" {
+			n, _ := strconv.Atoi(part)
+			k.Version = append(k.Version, n)
+		}
+	}
+
+	build := match[4]
+	if arch := archSuffix.FindStringSubmatch(build); arch != nil {
+		k.Arch = arch[1]
+		build = strings.TrimSuffix(build, arch[0])
+	}
+
+	if m := amazonRelease.FindStringSubmatch(build); m != nil {
+		k.ABI, k.Distro = m[1], m[2]
+	} else if m := redhatRelease.FindStringSubmatch(build); m != nil {
+		k.ABI, k.Distro = m[1], m[2]
+	} else if m := flavourRelease.FindStringSubmatch(build); m != nil {
+		k.ABI, k.Flavour = m[1], m[2]
+		if arch, ok := debianFlavours[k.Flavour]; ok {
+			k.Distro = "debian"
+			k.Arch = arch
+		} else if suseFlavours[k.Flavour] || strings.Contains(k.ABI, ".") {
+			k.Distro = "suse"
+		} else {
+			k.Distro = "ubuntu"
+		}
+	} else {
+		k.ABI = strings.TrimLeft(build, "-.")
+	}
+	return k
+}
+
+// sameFamily reports whether two releases come from the same distribution
+// and flavour and, when both name one, the same architecture
+func (k KernelRelease) sameFamily(other KernelRelease) bool {
+	if k.Distro != other.Distro || k.Flavour != other.Flavour {
+		return false
+	}
+	return k.Arch == "" || other.Arch == "" || k.Arch == other.Arch
+}
+
+// distance returns how far other is from k as the absolute differences of
+// the upstream version components followed by those of the numeric ABI
+// components, distances are ordered lexically
+func (k KernelRelease) distance(other KernelRelease) []int {
+	var d []int
+	for i := 0; i < 3; i++ {
+		d = append(d, abs(component(k.Version, i)-component(other.Version, i)))
+	}
+	kABI, otherABI := numericParts(k.ABI), numericParts(other.ABI)
+	for i := 0; i < len(kABI) || i < len(otherABI); i++ {
+		d = append(d, abs(component(kABI, i)-component(otherABI, i)))
+	}
+	return d
+}
+
+func compareDistances(a []int, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return compareInts(a[i], b[i])
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+func numericParts(version string) []int {
+	var parts []int
+	for _, part := range versionParts(version) {
+		n, err := strconv.Atoi(part)
+		if err == nil {
+			parts = append(parts, n)
+		}
+	}
+	return parts
+}
+
+func component(parts []int, i int) int {
+	if i < len(parts) {
+		return parts[i]
+	}
+	return 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// versionRange is a set of alternative version constraint lists, a version
+// is in the range when it satisfies every constraint of any alternative
+type versionRange [][]versionConstraint
+
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+var rangeOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// parseRange parses constraints such as ">=4.14.100 <4.15", constraints are
+// separated by spaces or commas and alternatives by "||". Versions compare
+// component by component so 4.15 sorts before 4.15.0-1-generic.
+func parseRange(spec string) (versionRange, error) {
+	var vr versionRange
+	for _, alternative := range strings.Split(spec, "||") {
+		var constraints []versionConstraint
+		fields := strings.FieldsFunc(alternative, func(c rune) bool {
+			return c == ' ' || c == ','
+		})
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			op := ""
+			for _, candidate := range rangeOperators {
+				if strings.HasPrefix(field, candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.New(fmt.Sprintf("invalid version constraint %q, expected eg. >=4.14", field))
+			}
+			version := strings.TrimPrefix(field, op)
+			// allow a space between the operator and the version
+			if version == "" && i+1 < len(fields) {
+				i++
+				version = fields[i]
+			}
+			if version == "" {
+				return nil, errors.New(fmt.Sprintf("version constraint %q has no version", field))
+			}
+			constraints = append(constraints, versionConstraint{op, version})
+		}
+		if len(constraints) == 0 {
+			return nil, errors.New(fmt.Sprintf("invalid version range %q", spec))
+		}
+		vr = append(vr, constraints)
+	}
+	return vr, nil
+}
+
+// contains reports whether version satisfies the range
+func (vr versionRange) contains(version string) bool {
+	for _, constraints := range vr {
+		matched := true
+		for _, c := range constraints {
+			if !c.satisfied(compareVersions(version, c.version)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionConstraint) satisfied(order int) bool {
+	switch c.op {
+	case ">=":
+		return order >= 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case "<":
+		return order < 0
+	case "!=":
+		return order != 0
+	}
+	return order == 0
+}
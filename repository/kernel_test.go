@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+)
+
+type compareTest struct {
+	a      string
+	b      string
+	result int
+}
+
+var comparetests = []compareTest{
+	{"4.4.10-22.54.amzn1.x86_64", "4.4.10-22.54.amzn1.x86_64", 0},
+	{"4.4.9-22.54.amzn1.x86_64", "4.4.10-22.54.amzn1.x86_64", -1},
+	{"4.4.10-22.55.amzn1.x86_64", "4.4.10-22.54.amzn1.x86_64", 1},
+	{"4.2.0-17-generic", "4.2.0-17", 1},
+	{"4.2.0-rc1", "4.2.0-1", -1},
+	{"3.10.0-957.el7.x86_64", "3.10.0-1062.el7.x86_64", -1},
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, input := range comparetests {
+		result := compareVersions(input.a, input.b)
+		if result != input.result {
+			t.Error(
+				"For", input.a, "and", input.b,
+				"expected", input.result,
+				"got", result,
+			)
+		}
+	}
+}
+
+var releasetests = []KernelRelease{
+	{"4.2.0-17-generic", []int{4, 2, 0}, "17", "ubuntu", "generic", ""},
+	{"5.4.0-1045-aws", []int{5, 4, 0}, "1045", "ubuntu", "aws", ""},
+	{"4.19.0-8-amd64", []int{4, 19, 0}, "8", "debian", "amd64", "x86_64"},
+	{"5.10.0-8-cloud-arm64", []int{5, 10, 0}, "8", "debian", "cloud-arm64", "aarch64"},
+	{"4.19.0-8-686", []int{4, 19, 0}, "8", "debian", "686", "i686"},
+	{"4.19.0-8-686-pae", []int{4, 19, 0}, "8", "debian", "686-pae", "i686"},
+	{"5.14.21-150400.24.11-64kb", []int{5, 14, 21}, "150400.24.11", "suse", "64kb", ""},
+	{"4.12.14-122.37-default", []int{4, 12, 14}, "122.37", "suse", "default", ""},
+	{"3.10.0-957.el7.x86_64", []int{3, 10, 0}, "957", "el7", "", "x86_64"},
+	{"5.14.0-70.13.1.el9_0.aarch64", []int{5, 14, 0}, "70.13.1", "el9", "", "aarch64"},
+	{"4.4.10-22.54.amzn1.x86_64", []int{4, 4, 10}, "22.54", "amzn1", "", "x86_64"},
+	{"4.14.177-139.253.amzn2.x86_64", []int{4, 14, 177}, "139.253", "amzn2", "", "x86_64"},
+	{"5.10.1", []int{5, 10, 1}, "", "upstream", "", ""},
+	{"6.1-rc3", []int{6, 1}, "rc3", "upstream", "", ""},
+}
+
+func TestParseKernelRelease(t *testing.T) {
+	for _, expected := range releasetests {
+		release := ParseKernelRelease(expected.Release)
+		if fmt.Sprint(release) != fmt.Sprint(expected) {
+			t.Error("For", expected.Release, "expected", expected, "got", release)
+		}
+	}
+}
+
+type rangeTest struct {
+	spec     string
+	version  string
+	contains bool
+	valid    bool
+}
+
+var rangetests = []rangeTest{
+	{">=4.14.100 <4.15", "4.14.177-139.253.amzn2.x86_64", true, true},
+	{">=4.14.100 <4.15", "4.14.99-1.amzn2.x86_64", false, true},
+	{">=4.14.100 <4.15", "4.15.0-1-generic", false, true},
+	{">= 4.14.100, < 4.15", "4.14.100", true, true},
+	{"<4.4 || >=5.10", "5.10.0-8-amd64", true, true},
+	{"<4.4 || >=5.10", "4.19.0-8-amd64", false, true},
+	{"!=4.2.0-17-generic", "4.2.0-17-generic", false, true},
+	{"=4.2.0-17-generic", "4.2.0-17-generic", true, true},
+	{"4.14", "", false, false},
+	{">=", "", false, false},
+	{"<4.4 ||", "", false, false},
+}
+
+func TestVersionRange(t *testing.T) {
+	for _, input := range rangetests {
+		vr, err := parseRange(input.spec)
+		if (err == nil) != input.valid {
+			t.Error("For", input.spec, "expected valid?", input.valid, "got err", err)
+			continue
+		}
+		if input.valid && vr.contains(input.version) != input.contains {
+			t.Error("For", input.spec, "and", input.version, "expected contains?", input.contains)
+		}
+	}
+}
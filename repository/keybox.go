@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// GnuPG 2.1+ stores public keys in a keybox (pubring.kbx), a sequence of
+// blobs each starting with a 4 byte length, a type and a version byte
+const (
+	keyboxHeaderBlob  = 1
+	keyboxOpenPGPBlob = 2
+	keyboxMagic       = "KBXf"
+)
+
+// isKeybox reports whether data starts with a keybox header blob
+func isKeybox(data []byte) bool {
+	return len(data) >= 12 && data[4] == keyboxHeaderBlob && string(data[8:12]) == keyboxMagic
+}
+
+// readKeybox returns the OpenPGP keys stored in a keybox file, X.509
+// certificates, empty blobs and keys using unsupported algorithms are skipped
+func readKeybox(data []byte) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 6 {
+			return nil, errors.New(fmt.Sprintf("truncated keybox blob at offset %d", offset))
+		}
+		blobLen := int(binary.BigEndian.Uint32(data[offset:]))
+		if blobLen < 6 || blobLen > len(data)-offset {
+			return nil, errors.New(fmt.Sprintf("invalid keybox blob length %d at offset %d", blobLen, offset))
+		}
+		blob := data[offset : offset+blobLen]
+		offset += blobLen
+
+		if blob[4] != keyboxOpenPGPBlob {
+			continue
+		}
+		if blobLen < 16 {
+			return nil, errors.New(fmt.Sprintf("truncated keybox OpenPGP blob at offset %d", offset-blobLen))
+		}
+		keyStart := int(binary.BigEndian.Uint32(blob[8:]))
+		keyLen := int(binary.BigEndian.Uint32(blob[12:]))
+		if keyStart > blobLen || keyLen > blobLen-keyStart {
+			return nil, errors.New(fmt.Sprintf("invalid keybox keyblock at offset %d", offset-blobLen))
+		}
+
+		entities, err := openpgp.ReadKeyRing(bytes.NewReader(blob[keyStart : keyStart+keyLen]))
+		if _, ok := err.(pgperrors.UnsupportedError); ok {
+			// eg. the ed25519 keys GnuPG 2.3+ creates by default
+			log.Debug(fmt.Sprintf("skipping keybox keyblock at offset %d: %s", offset-blobLen, err))
+			continue
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error reading keybox keyblock: %s", err))
+		}
+		keys = append(keys, entities...)
+	}
+	return keys, nil
+}
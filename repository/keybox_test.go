@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// keyboxBlob returns a keybox blob of blobType wrapping keyblock
+func keyboxBlob(blobType byte, keyblock []byte) []byte {
+	blob := make([]byte, 16, 16+len(keyblock))
+	binary.BigEndian.PutUint32(blob[0:], uint32(16+len(keyblock)))
+	blob[4] = blobType
+	blob[5] = 1
+	binary.BigEndian.PutUint32(blob[8:], 16)
+	binary.BigEndian.PutUint32(blob[12:], uint32(len(keyblock)))
+	return append(blob, keyblock...)
+}
+
+// testKeybox returns a keybox file holding entity, as written by GnuPG 2.1+
+func testKeybox(entity *openpgp.Entity) []byte {
+	header := make([]byte, 32)
+	binary.BigEndian.PutUint32(header[0:], 32)
+	header[4] = keyboxHeaderBlob
+	header[5] = 1
+	copy(header[8:], keyboxMagic)
+
+	var keyblock bytes.Buffer
+	entity.Serialize(&keyblock)
+
+	kbx := append(header, keyboxBlob(3, []byte("x509 certificate"))...)
+	return append(kbx, keyboxBlob(keyboxOpenPGPBlob, keyblock.Bytes())...)
+}
+
+// eddsaKeyblock returns an ed25519 public key packet as written by GnuPG
+// 2.3+, a key algorithm openpgp does not support
+func eddsaKeyblock() []byte {
+	oid := []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	body := []byte{4, 0x5e, 0x00, 0x00, 0x00, 22, byte(len(oid))}
+	body = append(body, oid...)
+	body = append(body, 0x01, 0x07, 0x40)
+	body = append(body, make([]byte, 32)...)
+	return append([]byte{0xc6, byte(len(body))}, body...)
+}
+
+func TestKeyringFormats(t *testing.T) {
+	entity, err := openpgp.NewEntity("marsho test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &gpgKey{entity}
+
+	var binaryRing bytes.Buffer
+	entity.Serialize(&binaryRing)
+	var armoredRing bytes.Buffer
+	armorWriter, _ := armor.Encode(&armoredRing, openpgp.PublicKeyType, nil)
+	entity.Serialize(armorWriter)
+	armorWriter.Close()
+
+	dir, err := ioutil.TempDir("", "marsho-keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var keyringTests = []struct {
+		name    string
+		data    []byte
+		version string
+	}{
+		{"pubring.kbx", testKeybox(entity), "kbx"},
+		{"pubring.gpg", binaryRing.Bytes(), "gpg"},
+		{"keys.asc", armoredRing.Bytes(), "armored"},
+	}
+
+	for _, test := range keyringTests {
+		path := filepath.Join(dir, test.name)
+		ioutil.WriteFile(path, test.data, 0644)
+
+		keyring, err := getDefaultKeyring(path)
+		if err != nil {
+			t.Error("For", test.name, "expected keyring got", err)
+			continue
+		}
+		if keyring.version != test.version {
+			t.Error("For", test.name, "expected format", test.version, "got", keyring.version)
+		}
+		if !keyring.contains(key) {
+			t.Error("For", test.name, "expected keyring to contain test key")
+		}
+	}
+
+	// GNUPGHOME is searched for a keybox before the legacy keyring
+	os.Setenv("GNUPGHOME", dir)
+	defer os.Unsetenv("GNUPGHOME")
+	keyring, err := getDefaultKeyring(GnuPGKeyring)
+	if err != nil || keyring.defaultKeyring != filepath.Join(dir, "pubring.kbx") {
+		t.Error("expected GNUPGHOME keybox got", keyring, err)
+	}
+
+	// keys openpgp cannot parse are skipped rather than failing the keybox
+	mixed := append(testKeybox(entity), keyboxBlob(keyboxOpenPGPBlob, eddsaKeyblock())...)
+	keys, err := readKeybox(mixed)
+	if err != nil || len(keys) != 1 {
+		t.Error("expected 1 key from a keybox with an ed25519 key got", len(keys), err)
+	}
+	keys, err = readKeybox(keyboxBlob(keyboxOpenPGPBlob, eddsaKeyblock()))
+	if err != nil || len(keys) != 0 {
+		t.Error("expected no keys from an ed25519 only keybox got", len(keys), err)
+	}
+
+	_, err = readKeybox(testKeybox(entity)[:40])
+	if err == nil {
+		t.Error("expected error for truncated keybox")
+	}
+}
@@ -1,5 +1,148 @@
 package repository
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 type Location struct {
 	Href string `xml:"href,attr"`
 }
+
+// isLocal reports whether the repository is a file:// url or a plain
+// directory path rather than an http(s) url
+func (r *Repository) isLocal() bool {
+	return strings.HasPrefix(r.BaseUrl, "file://") || !strings.Contains(r.BaseUrl, "://")
+}
+
+// localPath resolves href against a local repository root, refusing paths
+// that would escape the repository directory
+func (r *Repository) localPath(href string) (string, error) {
+	root := r.BaseUrl
+	if strings.HasPrefix(root, "file://") {
+		u, err := url.Parse(root)
+		if err != nil {
+			return "", err
+		}
+		root = u.Path
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(href))
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("%s is outside of repository %s", href, r.BaseUrl))
+	}
+	return path, nil
+}
+
+// open returns a reader for href relative to the repository base url,
+// reading from disk for local repositories and over http otherwise
+func (r *Repository) open(href string) (io.ReadCloser, error) {
+	if r.isLocal() {
+		path, err := r.localPath(href)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug(fmt.Sprintf("opening %s", path))
+		return os.Open(path)
+	}
+
+	url := fmt.Sprintf("%s%s", r.BaseUrl, href)
+	log.Debug(fmt.Sprintf("fetching %s", url))
+	resp, err := netClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("get %s returned %s", href, resp.Status))
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, statusError{resp.StatusCode, resp.Status}
+	}
+	return resp.Body, nil
+}
+
+// statusError is returned by open for unsuccessful http responses
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string {
+	return e.status
+}
+
+// notFound reports whether err means a file is missing from the repository,
+// S3 answers 403 rather than 404 for missing objects in unlistable buckets
+func notFound(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	if status, ok := err.(statusError); ok {
+		return status.code == http.StatusNotFound || status.code == http.StatusForbidden
+	}
+	return false
+}
+
+// hard limits for anything read from a repository whatever sizes its
+// metadata advertises
+const (
+	maxMetadataSize = 64 << 20
+	maxModuleSize   = 256 << 20
+)
+
+// fetchBytes reads href relative to the repository base url into memory,
+// refusing files larger than maxMetadataSize
+func (r *Repository) fetchBytes(href string) ([]byte, error) {
+	return r.fetchLimited(href, maxMetadataSize)
+}
+
+// fetchLimited reads href into memory, refusing files larger than limit
+func (r *Repository) fetchLimited(href string, limit int64) ([]byte, error) {
+	reader, err := r.open(href)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readLimited(reader, limit)
+}
+
+// readLimited reads reader to EOF without ever holding more than limit+1
+// bytes, an error is returned as soon as limit is exceeded
+func readLimited(reader io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New(fmt.Sprintf("size exceeds limit of %d bytes", limit))
+	}
+	return data, nil
+}
+
+// sizeLimit returns the advertised size as a read limit, sizes above the
+// hard limit are refused before anything is downloaded
+func sizeLimit(size int, max int64) (int64, error) {
+	if size < 0 || int64(size) > max {
+		return 0, errors.New(fmt.Sprintf("advertised size %d exceeds limit of %d bytes", size, max))
+	}
+	if size == 0 {
+		return max, nil
+	}
+	return int64(size), nil
+}
+
+// checkSize fails when data is not the advertised size, a zero size is not
+// advertised
+func checkSize(data []byte, size int) error {
+	if size != 0 && len(data) != size {
+		return errors.New(fmt.Sprintf("size mismatch expected: %d found: %d", size, len(data)))
+	}
+	return nil
+}
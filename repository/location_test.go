@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestRepo writes repository files to a new temporary directory
+func writeTestRepo(t *testing.T, files map[string][]byte) string {
+	repoDir, err := ioutil.TempDir("", "marsho-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, data := range files {
+		path = filepath.Join(repoDir, filepath.FromSlash(path))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repoDir
+}
+
+func TestLocalRepository(t *testing.T) {
+	repoDir := writeTestRepo(t, testRepoFiles(t, unzippedManifestData))
+	defer os.RemoveAll(repoDir)
+
+	for _, baseUrl := range []string{repoDir + "/", "file://" + repoDir + "/"} {
+		r := DefaultRepository()
+		r.BaseUrl = baseUrl
+		r.SkipGPGVerify = true
+		r.Offline = true
+		r.CacheDir = ""
+
+		manifest, err := r.List()
+		if err != nil || len(manifest.Modules) != 1 {
+			t.Error(
+				"For", baseUrl,
+				"expected 1 module got", len(manifest.Modules),
+				"with err", err,
+			)
+		}
+	}
+}
+
+type localPathTest struct {
+	href  string
+	valid bool
+}
+
+var localpathtests = []localPathTest{
+	{"repodata/repomd.xml", true},
+	{"modules/lime-4.2.0-17-generic.ko", true},
+	{"../outside.ko", false},
+	{"modules/../../outside.ko", false},
+}
+
+func TestLocalPath(t *testing.T) {
+	r := DefaultRepository()
+	r.BaseUrl = "file:///mnt/usb/lime-repo/"
+	for _, input := range localpathtests {
+		_, err := r.localPath(input.href)
+		if (err == nil) != input.valid {
+			t.Error(
+				"For", input.href,
+				"expected valid?", input.valid,
+				"got err", err,
+			)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ryanuber/go-glob"
+	"regexp"
+	"sort"
+	"strings"
 )
 
 type Manifest struct {
@@ -15,28 +18,258 @@ type Module struct {
 	ModuleType string   `xml:"type,attr"`
 	Name       string   `xml:"name"`
 	Arch       string   `xml:"arch"`
-	Checksum   string   `xml:"checksum"`
+	Checksum   Checksum `xml:"checksum"`
 	Version    string   `xml:"version"`
 	Packager   string   `xml:"packager"`
 	Location   Location `xml:"location"`
 	Signature  Location `xml:"signature"`
 	Platform   string   `xml:"platform"`
+	Source     string   `xml:"-"`
 }
 
-func (m *Manifest) find(version string) ([]Module, error) {
-	var modCollection []Module
+// find returns the modules matching f, failing with the closest modules
+// when none match
+func (m *Manifest) find(f Filter) ([]Module, error) {
+	modCollection, err := m.Filter(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(modCollection) > 0 {
+		return modCollection, nil
+	} else {
+		// suggest modules matching every filter other than the version
+		others := f
+		others.Version, others.Regex, others.Range = "", "", ""
+		candidates, _ := m.Filter(others)
+		nearest := Manifest{Modules: candidates}
+		return modCollection, notFoundError(f, nearest.Nearest(f.Version, maxSuggestions))
+	}
+}
+
+// maxSuggestions is how many of the closest modules are suggested when a
+// kernel version is not found
+const maxSuggestions = 5
+
+// notFoundError reports that no module matches f with the closest modules
+func notFoundError(f Filter, nearest []Module) error {
+	message := fmt.Sprintf("repository: module version %s not found", f.Version)
+	if f != (Filter{Version: f.Version}) {
+		message = fmt.Sprintf("repository: no modules match %s", f)
+	}
+	if len(nearest) == 0 {
+		return errors.New(message)
+	}
+	var versions []string
+	for _, mod := range nearest {
+		versions = append(versions, mod.Version)
+	}
+	return errors.New(fmt.Sprintf("%s, closest matches: %s", message, strings.Join(versions, ", ")))
+}
+
+// Nearest returns at most n modules for the same distribution, flavour and
+// architecture as the kernel release version ranked by version distance,
+// closest and then newest first. Glob patterns have no nearest modules.
+func (m *Manifest) Nearest(version string, n int) []Module {
+	if version == "" || strings.ContainsAny(version, "*?[") {
+		return nil
+	}
+	release := ParseKernelRelease(version)
+
+	type candidate struct {
+		mod      Module
+		distance []int
+	}
+	var candidates []candidate
+	seen := map[string]bool{}
+	for _, mod := range m.Modules {
+		other := ParseKernelRelease(mod.Version)
+		if seen[mod.Version] || !release.sameFamily(other) {
+			continue
+		}
+		seen[mod.Version] = true
+		candidates = append(candidates, candidate{mod, release.distance(other)})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		order := compareDistances(candidates[i].distance, candidates[j].distance)
+		if order == 0 {
+			return compareVersions(candidates[i].mod.Version, candidates[j].mod.Version) > 0
+		}
+		return order < 0
+	})
+
+	var nearest []Module
+	for i := 0; i < len(candidates) && i < n; i++ {
+		nearest = append(nearest, candidates[i].mod)
+	}
+	return nearest
+}
+
+// lookup returns the module named name
+func (m *Manifest) lookup(name string) (Module, bool) {
+	for _, mod := range m.Modules {
+		if mod.Name == name {
+			return mod, true
+		}
+	}
+	return Module{}, false
+}
+
+// Filter narrows a manifest to modules matching every non-empty field,
+// Regex is a regular expression and Range version constraints such as
+// ">=4.14.100 <4.15" the version must also satisfy, every other field is a
+// glob pattern
+type Filter struct {
+	Version  string
+	Arch     string
+	Platform string
+	Type     string
+	Packager string
+	Regex    string
+	Range    string
+}
+
+func (f Filter) match(mod Module) bool {
+	for _, field := range [][2]string{
+		{f.Version, mod.Version},
+		{f.Arch, mod.Arch},
+		{f.Platform, mod.Platform},
+		{f.Type, mod.ModuleType},
+		{f.Packager, mod.Packager},
+	} {
+		if field[0] != "" && !glob.Glob(field[0], field[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matcher compiles the regular expression and range of f into a predicate
+// applying every field of f
+func (f Filter) matcher() (func(Module) bool, error) {
+	var re *regexp.Regexp
+	if f.Regex != "" {
+		var err error
+		re, err = regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid version regex %q: %s", f.Regex, err))
+		}
+	}
+	var vr versionRange
+	if f.Range != "" {
+		var err error
+		vr, err = parseRange(f.Range)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(mod Module) bool {
+		if !f.match(mod) {
+			return false
+		}
+		if re != nil && !re.MatchString(mod.Version) {
+			return false
+		}
+		return vr == nil || vr.contains(mod.Version)
+	}, nil
+}
 
+// String describes the non-empty fields of f
+func (f Filter) String() string {
+	var terms []string
+	for _, term := range [][2]string{
+		{"", f.Version},
+		{"arch ", f.Arch},
+		{"platform ", f.Platform},
+		{"type ", f.Type},
+		{"packager ", f.Packager},
+		{"regex ", f.Regex},
+		{"range ", f.Range},
+	} {
+		if term[1] != "" {
+			terms = append(terms, fmt.Sprintf("%s'%s'", term[0], term[1]))
+		}
+	}
+	if len(terms) == 0 {
+		return "all modules"
+	}
+	return strings.Join(terms, ", ")
+}
+
+// Filter returns the modules in the manifest matching f
+func (m *Manifest) Filter(f Filter) ([]Module, error) {
+	match, err := f.matcher()
+	if err != nil {
+		return nil, err
+	}
+	var modCollection []Module
 	for _, mod := range m.Modules {
-		if glob.Glob(version, mod.Version) {
+		if match(mod) {
 			modCollection = append(modCollection, mod)
 		}
 	}
+	return modCollection, nil
+}
 
-	if len(modCollection) > 0 {
-		return modCollection, nil
-	} else {
-		return modCollection, errors.New(fmt.Sprintf("repository: module version %s not found", version))
+// SortFields are the fields modules can be sorted by
+var SortFields = []string{"version", "name", "arch", "platform", "type", "packager", "source"}
+
+// SortModules orders modules by field, kernel versions compare numerically
+// and ties are broken by version then manifest order
+func SortModules(modules []Module, field string) error {
+	fields := map[string]func(Module) string{
+		"version":  func(mod Module) string { return mod.Version },
+		"name":     func(mod Module) string { return mod.Name },
+		"arch":     func(mod Module) string { return mod.Arch },
+		"platform": func(mod Module) string { return mod.Platform },
+		"type":     func(mod Module) string { return mod.ModuleType },
+		"packager": func(mod Module) string { return mod.Packager },
+		"source":   func(mod Module) string { return mod.Source },
+	}
+	value, ok := fields[field]
+	if !ok {
+		return errors.New(fmt.Sprintf(
+			"unknown sort field %s, expected one of %s", field, strings.Join(SortFields, ", "),
+		))
+	}
+
+	sort.SliceStable(modules, func(i, j int) bool {
+		if field != "version" {
+			a, b := value(modules[i]), value(modules[j])
+			if a != b {
+				return a < b
+			}
+		}
+		return compareVersions(modules[i].Version, modules[j].Version) < 0
+	})
+	return nil
+}
+
+// Selector chooses a single module when a kernel version matches several
+type Selector func(modules []Module) (Module, error)
+
+// SelectFirst chooses the first matching module in manifest order
+func SelectFirst(modules []Module) (Module, error) {
+	if len(modules) == 0 {
+		return Module{}, errors.New("repository: no modules to select from")
+	}
+	return modules[0], nil
+}
+
+// SelectLatest chooses the matching module with the highest kernel version
+func SelectLatest(modules []Module) (Module, error) {
+	if len(modules) == 0 {
+		return Module{}, errors.New("repository: no modules to select from")
+	}
+	latest := modules[0]
+	for _, mod := range modules[1:] {
+		if compareVersions(mod.Version, latest.Version) > 0 {
+			latest = mod
+		}
 	}
+	return latest, nil
 }
 
 func moduleManifest(data []byte) Manifest {
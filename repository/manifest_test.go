@@ -3,6 +3,7 @@ package repository
 import (
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -85,11 +86,11 @@ func TestModuleManifest(t *testing.T) {
 		)
 	}
 
-	if mod.Checksum != manifesttest.modChecksum {
+	if mod.Checksum.Value != manifesttest.modChecksum {
 		t.Error(
 			"For\n", string(*manifesttest.data),
 			"expected checksum", manifesttest.modChecksum,
-			"got", mod.Checksum,
+			"got", mod.Checksum.Value,
 		)
 	}
 
@@ -161,7 +162,7 @@ var findtests = []findTest{
 func TestFind(t *testing.T) {
 	man := moduleManifest(*manifesttest.data)
 	for _, input := range findtests {
-		modules, err := man.find(input.version)
+		modules, err := man.find(Filter{Version: input.version})
 		if len(modules) != input.results {
 			t.Error(
 				"For", input.version,
@@ -172,3 +173,143 @@ func TestFind(t *testing.T) {
 		}
 	}
 }
+
+var selectModules = []Module{
+	{Name: "lime-4.4.9-22.54.amzn1.x86_64.ko", Version: "4.4.9-22.54.amzn1.x86_64"},
+	{Name: "lime-4.4.10-22.54.amzn1.x86_64.ko", Version: "4.4.10-22.54.amzn1.x86_64"},
+	{Name: "lime-4.4.10-21.54.amzn1.x86_64.ko", Version: "4.4.10-21.54.amzn1.x86_64"},
+}
+
+func TestSelectors(t *testing.T) {
+	first, err := SelectFirst(selectModules)
+	if err != nil || first.Name != selectModules[0].Name {
+		t.Error("SelectFirst expected", selectModules[0].Name, "got", first.Name, "with err", err)
+	}
+
+	latest, err := SelectLatest(selectModules)
+	if err != nil || latest.Name != selectModules[1].Name {
+		t.Error("SelectLatest expected", selectModules[1].Name, "got", latest.Name, "with err", err)
+	}
+
+	if _, err := SelectLatest(nil); err == nil {
+		t.Error("SelectLatest expected error for empty module list")
+	}
+}
+
+var filterModules = []Module{
+	{Name: "a.ko", Version: "5.10.0-8-amd64", Arch: "x86_64", Platform: "linux", ModuleType: "lime", Packager: "lime-compiler"},
+	{Name: "b.ko", Version: "5.10.0-8-arm64", Arch: "aarch64", Platform: "linux", ModuleType: "lime", Packager: "lime-compiler"},
+	{Name: "c.ko", Version: "5.10.1-1-arm64", Arch: "aarch64", Platform: "linux", ModuleType: "lime", Packager: "marsho"},
+	{Name: "d.ko", Version: "4.19.0-1-arm64", Arch: "aarch64", Platform: "linux", ModuleType: "avml", Packager: "lime-compiler"},
+}
+
+type filterTest struct {
+	filter Filter
+	names  string
+}
+
+var filtertests = []filterTest{
+	{Filter{}, "a.ko b.ko c.ko d.ko"},
+	{Filter{Arch: "aarch64", Packager: "lime-compiler", Version: "5.10*"}, "b.ko"},
+	{Filter{Arch: "aarch64", Type: "lime"}, "b.ko c.ko"},
+	{Filter{Type: "avml"}, "d.ko"},
+	{Filter{Packager: "lime-*"}, "a.ko b.ko d.ko"},
+	{Filter{Platform: "windows"}, ""},
+	{Filter{Regex: `^5\.10\.`, Arch: "aarch64"}, "b.ko c.ko"},
+	{Filter{Range: ">=5.10.1", Packager: "marsho"}, "c.ko"},
+	{Filter{Range: ">=4.19 <5.10.1", Regex: "arm64$"}, "b.ko d.ko"},
+}
+
+func TestFilter(t *testing.T) {
+	man := Manifest{Modules: filterModules}
+	for _, invalid := range []Filter{{Regex: "5.10("}, {Range: "~5.10"}} {
+		if _, err := man.Filter(invalid); err == nil {
+			t.Error("For", invalid, "expected error")
+		}
+	}
+	for _, input := range filtertests {
+		var names []string
+		modules, err := man.Filter(input.filter)
+		if err != nil {
+			t.Error("For", input.filter, "unexpected error", err)
+		}
+		for _, mod := range modules {
+			names = append(names, mod.Name)
+		}
+		if strings.Join(names, " ") != input.names {
+			t.Error("For", input.filter, "expected", input.names, "got", names)
+		}
+	}
+}
+
+var nearestModules = Manifest{Modules: []Module{
+	{Version: "4.4.9-22.54.amzn1.x86_64"},
+	{Version: "4.4.10-22.54.amzn1.x86_64"},
+	{Version: "4.4.10-21.54.amzn1.x86_64"},
+	{Version: "4.9.20-10.30.amzn1.x86_64"},
+	{Version: "4.14.177-139.253.amzn2.x86_64"},
+	{Version: "4.4.10-22-generic"},
+	{Version: "4.4.10-22.54.amzn1.aarch64"},
+}}
+
+type nearestTest struct {
+	version  string
+	versions string
+}
+
+var nearesttests = []nearestTest{
+	{"4.4.10-22.55.amzn1.x86_64", "4.4.10-22.54.amzn1.x86_64 4.4.10-21.54.amzn1.x86_64 4.4.9-22.54.amzn1.x86_64"},
+	{"4.4.11-22.54.amzn1.x86_64", "4.4.10-22.54.amzn1.x86_64 4.4.10-21.54.amzn1.x86_64 4.4.9-22.54.amzn1.x86_64"},
+	{"4.14.200-140.1.amzn2.x86_64", "4.14.177-139.253.amzn2.x86_64"},
+	{"4.4.0-21-generic", "4.4.10-22-generic"},
+	{"4.4.0-21-lowlatency", ""},
+	{"4.4.*", ""},
+}
+
+func TestNearest(t *testing.T) {
+	for _, input := range nearesttests {
+		var versions []string
+		for _, mod := range nearestModules.Nearest(input.version, 3) {
+			versions = append(versions, mod.Version)
+		}
+		if strings.Join(versions, " ") != input.versions {
+			t.Error("For", input.version, "expected", input.versions, "got", versions)
+		}
+	}
+
+	_, err := nearestModules.find(Filter{Version: "4.4.11-22.54.amzn1.x86_64"})
+	if err == nil || !strings.Contains(err.Error(), "closest matches: 4.4.10-22.54.amzn1.x86_64") {
+		t.Error("expected closest matches in not found error got", err)
+	}
+}
+
+type sortTest struct {
+	field string
+	names string
+	valid bool
+}
+
+var sorttests = []sortTest{
+	{"version", "d.ko a.ko b.ko c.ko", true},
+	{"arch", "d.ko b.ko c.ko a.ko", true},
+	{"packager", "d.ko a.ko b.ko c.ko", true},
+	{"checksum", "", false},
+}
+
+func TestSortModules(t *testing.T) {
+	for _, input := range sorttests {
+		modules := append([]Module{}, filterModules...)
+		err := SortModules(modules, input.field)
+		if (err == nil) != input.valid {
+			t.Error("For", input.field, "expected valid?", input.valid, "got err", err)
+			continue
+		}
+		var names []string
+		for _, mod := range modules {
+			names = append(names, mod.Name)
+		}
+		if input.valid && strings.Join(names, " ") != input.names {
+			t.Error("For", input.field, "expected", input.names, "got", names)
+		}
+	}
+}
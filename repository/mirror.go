@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Mirror replicates the repository into dest using the same layout, only
+// modules matching filter are downloaded and modules already present with a
+// matching checksum are left untouched. Repository metadata is copied
+// verbatim so it still verifies against the original signing key, or the
+// original TUF root. Metadata is only published once every mirrored module
+// is in place, a filtered mirror publishes the upstream metadata unchanged so
+// modules it left out fail to download and fall back to the next repository,
+// a failed mirror holds modules only.
+func (r *Repository) Mirror(dest string, filter Filter, workers int) ([]FetchResult, error) {
+	files, err := r.metadataFiles()
+	if err != nil {
+		return nil, err
+	}
+	repo := files.metadata
+
+	primary, err := repo.Primary()
+	if err != nil {
+		return nil, err
+	}
+	gzManifest, data, err := r.downloadData(primary)
+	if err != nil {
+		return nil, err
+	}
+	manifest := moduleManifest(data)
+	modules, err := manifest.Filter(filter)
+	if err != nil {
+		return nil, err
+	}
+	log.Info(fmt.Sprintf(
+		"mirroring %d of %d modules from %s",
+		len(modules), len(manifest.Modules), r.BaseUrl,
+	))
+
+	results := forEach(modules, workers, func(mod Module) FetchResult {
+		result := r.mirrorModule(dest, mod)
+		if result.Err != nil {
+			log.Error(result.Err)
+		}
+		return result
+	})
+
+	if failed := failures(results); failed > 0 {
+		log.Warning(fmt.Sprintf(
+			"not publishing repository metadata to %s, %d of %d modules failed",
+			dest, failed, len(results),
+		))
+		return results, nil
+	}
+	if len(results) < len(manifest.Modules) {
+		log.Info(fmt.Sprintf(
+			"%d of %d modules were filtered out, %s lists them but does not hold them",
+			len(manifest.Modules)-len(results), len(manifest.Modules), dest,
+		))
+	}
+
+	// metadata is written last so the mirror never advertises modules
+	// before they are in place, every data entry is mirrored so additional
+	// indexes still verify against repomd.xml
+	for _, entry := range repo.Data {
+		gzData := gzManifest
+		if entry != primary {
+			gzData, _, err = r.downloadData(entry)
+			if err != nil {
+				return results, err
+			}
+		}
+		err = writeMirrorFile(dest, entry.Location.Href, gzData)
+		if err != nil {
+			return results, err
+		}
+	}
+	err = r.mirrorSigningFile(dest, r.signingKey, files.signingKey)
+	if err != nil {
+		return results, err
+	}
+	err = r.mirrorSigningFile(dest, r.metaDir+r.repoMetaSig, files.signature)
+	if err != nil {
+		return results, err
+	}
+	err = writeMirrorFile(dest, r.metaDir+r.repoMeta, files.repomd)
+	if err != nil {
+		return results, err
+	}
+	if r.tuf != nil {
+		names, roleFiles := r.tuf.roleFiles()
+		for _, name := range names {
+			err = writeMirrorFile(dest, name, roleFiles[name])
+			if err != nil {
+				return results, err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// failures counts the modules that could not be mirrored
+func failures(results []FetchResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r *Repository) mirrorModule(dest string, mod Module) FetchResult {
+	result := FetchResult{Module: mod}
+
+	localPath, err := mirrorPath(dest, mod.Location.Href)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Path = localPath
+
+	sigPath := ""
+	if r.SkipGPGVerify == false && r.tuf == nil {
+		sigPath, err = mirrorPath(dest, mod.Signature.Href)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	if mirrorCurrent(localPath, sigPath, mod.Checksum) {
+		log.Debug(fmt.Sprintf("module %s is up to date", mod.Name))
+		result.UpToDate = true
+		return result
+	}
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	sig, err := r.downloadTo(mod, localPath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Verified = r.verification()
+	if mod.Signature.Href != "" {
+		result.Err = r.mirrorSigningFile(dest, mod.Signature.Href, sig)
+	}
+	log.Info(fmt.Sprintf("mirrored %s", mod.Name))
+
+	return result
+}
+
+// mirrorSigningFile writes a verified key or signature into the mirror, when
+// verification is disabled the file is copied as is if the repository has it
+func (r *Repository) mirrorSigningFile(dest string, href string, data []byte) error {
+	if data == nil {
+		var err error
+		data, err = r.fetchBytes(href)
+		if err != nil {
+			log.Warning(fmt.Sprintf("not mirroring %s: %s", href, err))
+			return nil
+		}
+	}
+	return writeMirrorFile(dest, href, data)
+}
+
+// mirrorCurrent reports whether a previously mirrored module still matches
+// checksum and, when verifying, still has its signature alongside it
+func mirrorCurrent(localPath string, sigPath string, checksum Checksum) bool {
+	if sigPath != "" {
+		if _, err := os.Stat(sigPath); err != nil {
+			return false
+		}
+	}
+
+	modFile, err := os.Open(localPath)
+	if err != nil {
+		return false
+	}
+	defer modFile.Close()
+
+	hash, err := checksum.newHash()
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(hash, modFile)
+	if err != nil {
+		return false
+	}
+	return checksum.matches(hash.Sum(nil))
+}
+
+// mirrorPath resolves a repository href inside dest
+func mirrorPath(dest string, href string) (string, error) {
+	mirror := Repository{BaseUrl: dest}
+	return mirror.localPath(href)
+}
+
+func writeMirrorFile(dest string, href string, data []byte) error {
+	path, err := mirrorPath(dest, href)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to write %s: %s", path, err))
+	}
+	return nil
+}
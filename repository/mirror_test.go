@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var mirrorManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<modules>
+  <module type="lime">
+    <name>lime-4.2.0-17-generic.ko</name>
+    <arch>x86_64</arch>
+    <checksum>%[1]s</checksum>
+    <version>4.2.0-17-generic</version>
+    <packager>lime-compiler</packager>
+    <location href="modules/lime-4.2.0-17-generic.ko"/>
+    <platform>linux</platform>
+  </module>
+  <module type="lime">
+    <name>lime-4.2.0-17-generic-arm64.ko</name>
+    <arch>aarch64</arch>
+    <checksum>%[1]s</checksum>
+    <version>4.2.0-17-generic</version>
+    <packager>lime-compiler</packager>
+    <location href="modules/lime-4.2.0-17-generic-arm64.ko"/>
+    <platform>linux</platform>
+  </module>
+</modules>`
+
+func TestMirror(t *testing.T) {
+	module := []byte("lime kernel module")
+	sum := sha256.Sum256(module)
+	manifestData := []byte(fmt.Sprintf(mirrorManifest, hex.EncodeToString(sum[:])))
+
+	files := testRepoFiles(t, manifestData)
+	files["/modules/lime-4.2.0-17-generic.ko"] = module
+	files["/modules/lime-4.2.0-17-generic-arm64.ko"] = module
+	srcDir := writeTestRepo(t, files)
+	defer os.RemoveAll(srcDir)
+
+	dest, err := ioutil.TempDir("", "marsho-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	r := DefaultRepository()
+	r.BaseUrl = srcDir + "/"
+	r.SkipGPGVerify = true
+
+	for run, upToDate := range []bool{false, true} {
+		results, err := r.Mirror(dest, Filter{Arch: "x86_64"}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatal("For run", run, "expected 1 result got", len(results))
+		}
+		if results[0].Err != nil || results[0].UpToDate != upToDate {
+			t.Error(
+				"For run", run,
+				"expected up to date?", upToDate,
+				"got", results[0].UpToDate,
+				"with err", results[0].Err,
+			)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "modules", "lime-4.2.0-17-generic-arm64.ko")); err == nil {
+		t.Error("expected filtered module to be excluded from the mirror")
+	}
+
+	// a filtered mirror serves the modules it holds from the upstream
+	// metadata and fails for the ones it left out
+	mirror := DefaultRepository()
+	mirror.BaseUrl = dest + "/"
+	mirror.SkipGPGVerify = true
+	manifest, err := mirror.List()
+	if err != nil || len(manifest.Modules) != 2 {
+		t.Fatal("expected filtered mirror manifest with 2 modules got", len(manifest.Modules), "with err", err)
+	}
+	workDir, err := ioutil.TempDir("", "marsho-mirror-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	cwd, _ := os.Getwd()
+	os.Chdir(workDir)
+	defer os.Chdir(cwd)
+	for arch, mirrored := range map[string]bool{"x86_64": true, "aarch64": false} {
+		choose := func(modules []Module) (Module, error) {
+			for _, mod := range modules {
+				if mod.Arch == arch {
+					return mod, nil
+				}
+			}
+			return Module{}, errors.New(fmt.Sprintf("no %s module", arch))
+		}
+		_, err = mirror.Get("4.2.0-17-generic", choose)
+		if (err == nil) != mirrored {
+			t.Error("For", arch, "expected fetch to succeed?", mirrored, "got err", err)
+		}
+	}
+
+	results, err := r.Mirror(dest, Filter{}, 2)
+	if err != nil || len(results) != 2 {
+		t.Fatal("expected 2 results got", len(results), "with err", err)
+	}
+
+	// the mirror must be usable as a repository itself
+	manifest, err = mirror.List()
+	if err != nil || len(manifest.Modules) != 2 {
+		t.Error("expected mirrored manifest with 2 modules got", len(manifest.Modules), "with err", err)
+	}
+
+	// a mirror with failed modules must not advertise them
+	os.Remove(filepath.Join(srcDir, "modules", "lime-4.2.0-17-generic-arm64.ko"))
+	failedDest, err := ioutil.TempDir("", "marsho-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(failedDest)
+	results, err = r.Mirror(failedDest, Filter{}, 2)
+	if err != nil || len(results) != 2 || results[1].Err == nil {
+		t.Fatal("expected a failed module got", results, "with err", err)
+	}
+	if _, err := os.Stat(filepath.Join(failedDest, "repodata", "repomd.xml")); err == nil {
+		t.Error("expected no metadata in a mirror with failed modules")
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"time"
+)
+
+// minKeyBits is the smallest RSA or DSA key accepted without AllowWeakCrypto
+const minKeyBits = 2048
+
+// Policy decides which signatures are acceptable beyond being
+// cryptographically valid. The zero value is the strict default, revoked
+// signers are always rejected.
+type Policy struct {
+	AllowWeakCrypto  bool
+	AllowExpiredKeys bool
+}
+
+// signatureInfo is what the policy needs to know about a detached signature
+type signatureInfo struct {
+	issuer  uint64
+	hash    crypto.Hash
+	created time.Time
+}
+
+// readSignatureInfo returns details of the first signature in sigData made
+// by a key in keys, the same signature openpgp.CheckDetachedSignature checks
+func readSignatureInfo(keys openpgp.EntityList, sigData []byte) (signatureInfo, []openpgp.Key, error) {
+	packets := packet.NewReader(bytes.NewReader(sigData))
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			return signatureInfo{}, nil, errors.New("signature made by unknown entity")
+		} else if err != nil {
+			return signatureInfo{}, nil, err
+		}
+
+		var info signatureInfo
+		switch sig := p.(type) {
+		case *packet.Signature:
+			if sig.IssuerKeyId == nil {
+				return signatureInfo{}, nil, errors.New("signature doesn't have an issuer")
+			}
+			info = signatureInfo{*sig.IssuerKeyId, sig.Hash, sig.CreationTime}
+		case *packet.SignatureV3:
+			info = signatureInfo{sig.IssuerKeyId, sig.Hash, sig.CreationTime}
+		default:
+			return signatureInfo{}, nil, errors.New("non signature packet found")
+		}
+
+		if issuerKeys := keys.KeysById(info.issuer); len(issuerKeys) > 0 {
+			return info, issuerKeys, nil
+		}
+	}
+}
+
+// checkRevoked rejects signatures from revoked keys, which the openpgp
+// package otherwise reports as an unknown entity
+func checkRevoked(key openpgp.Key) error {
+	signer := &gpgKey{key.Entity}
+	if len(key.Entity.Revocations) > 0 {
+		return errors.New(fmt.Sprintf(
+			"signing key %s has been revoked%s", signer.fingerprint(),
+			revocationReason(key.Entity.Revocations[0]),
+		))
+	}
+	if key.SelfSignature != nil && key.SelfSignature.RevocationReason != nil {
+		return errors.New(fmt.Sprintf(
+			"signing subkey %X of %s has been revoked%s", key.PublicKey.KeyId, signer.fingerprint(),
+			revocationReason(key.SelfSignature),
+		))
+	}
+	return nil
+}
+
+// check applies the policy to a signature verified against key
+func (p Policy) check(key openpgp.Key, sig signatureInfo, now time.Time) error {
+	signer := &gpgKey{key.Entity}
+
+	if !p.AllowWeakCrypto {
+		switch sig.hash {
+		case crypto.MD5, crypto.SHA1, crypto.RIPEMD160:
+			return errors.New(fmt.Sprintf(
+				"signature by %s uses weak hash algorithm %s, set allow_weak_crypto to accept it",
+				signer.fingerprint(), hashName(sig.hash),
+			))
+		}
+
+		switch key.PublicKey.PubKeyAlgo {
+		case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoDSA:
+			bits, err := key.PublicKey.BitLength()
+			if err != nil {
+				return err
+			}
+			if int(bits) < minKeyBits {
+				return errors.New(fmt.Sprintf(
+					"signing key %s is a weak %d bit %s key, set allow_weak_crypto to accept it",
+					signer.fingerprint(), bits, algorithmName(key.PublicKey.PubKeyAlgo),
+				))
+			}
+		}
+	}
+
+	if !p.AllowExpiredKeys {
+		expires := keyExpiry(key.Entity.PrimaryKey, primaryIdentity(key.Entity))
+		if key.PublicKey != key.Entity.PrimaryKey {
+			subkeyExpires := keyExpiry(key.PublicKey, key.SelfSignature)
+			if !subkeyExpires.IsZero() && (expires.IsZero() || subkeyExpires.Before(expires)) {
+				expires = subkeyExpires
+			}
+		}
+		if !expires.IsZero() && now.After(expires) {
+			return errors.New(fmt.Sprintf(
+				"signing key %s expired on %s, set allow_expired_keys to accept it",
+				signer.fingerprint(), expires.UTC().Format("2006-01-02"),
+			))
+		}
+	}
+
+	return nil
+}
+
+// keyExpiry returns when pub expires according to its self signature, or
+// the zero time when it never expires
+func keyExpiry(pub *packet.PublicKey, sig *packet.Signature) time.Time {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return pub.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// primaryIdentity returns the self signature of the identity marked primary,
+// or of the first identity by name
+func primaryIdentity(entity *openpgp.Entity) *packet.Signature {
+	var primary *openpgp.Identity
+	for _, name := range identityNames(entity) {
+		ident := entity.Identities[name]
+		if primary == nil || (ident.SelfSignature != nil &&
+			ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId) {
+			primary = ident
+		}
+	}
+	if primary == nil {
+		return nil
+	}
+	return primary.SelfSignature
+}
+
+func revocationReason(sig *packet.Signature) string {
+	if sig.RevocationReasonText != "" {
+		return ": " + sig.RevocationReasonText
+	}
+	return ""
+}
+
+func hashName(hash crypto.Hash) string {
+	switch hash {
+	case crypto.MD5:
+		return "MD5"
+	case crypto.SHA1:
+		return "SHA1"
+	case crypto.RIPEMD160:
+		return "RIPEMD160"
+	}
+	return fmt.Sprintf("hash %d", hash)
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"bytes"
+	"crypto"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"testing"
+	"time"
+)
+
+type policyTest struct {
+	name   string
+	entity *openpgp.Entity
+	hash   crypto.Hash
+	policy Policy
+	valid  bool
+}
+
+// policyEntity returns a signing key with bits created at created, expiring
+// after lifetime unless it is zero
+func policyEntity(t *testing.T, bits int, created time.Time, lifetime uint32) *openpgp.Entity {
+	config := &packet.Config{RSABits: bits, Time: func() time.Time { return created }}
+	entity, err := openpgp.NewEntity("marsho test", "", "test@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime != 0 {
+		for _, ident := range entity.Identities {
+			ident.SelfSignature.KeyLifetimeSecs = &lifetime
+		}
+	}
+	return entity
+}
+
+func TestPolicy(t *testing.T) {
+	now := time.Now()
+	strong := policyEntity(t, 2048, now, 0)
+	weak := policyEntity(t, 1024, now, 0)
+	expired := policyEntity(t, 2048, now.Add(-48*time.Hour), 24*60*60)
+	current := policyEntity(t, 2048, now.Add(-48*time.Hour), 72*60*60)
+	revoked := policyEntity(t, 2048, now, 0)
+	revoked.Revocations = append(revoked.Revocations, &packet.Signature{
+		SigType:              packet.SigTypeKeyRevocation,
+		RevocationReasonText: "key compromised",
+	})
+	lax := Policy{AllowWeakCrypto: true, AllowExpiredKeys: true}
+
+	policytests := []policyTest{
+		{"strong key", strong, crypto.SHA256, Policy{}, true},
+		{"sha512 signature", strong, crypto.SHA512, Policy{}, true},
+		{"sha1 signature", strong, crypto.SHA1, Policy{}, false},
+		{"sha1 signature allowed", strong, crypto.SHA1, Policy{AllowWeakCrypto: true}, true},
+		{"1024 bit key", weak, crypto.SHA256, Policy{}, false},
+		{"1024 bit key allowed", weak, crypto.SHA256, Policy{AllowWeakCrypto: true}, true},
+		{"expired key", expired, crypto.SHA256, Policy{}, false},
+		{"expired key allowed", expired, crypto.SHA256, Policy{AllowExpiredKeys: true}, true},
+		{"unexpired key", current, crypto.SHA256, Policy{}, true},
+		{"revoked key", revoked, crypto.SHA256, lax, false},
+	}
+
+	data := []byte("signed repository metadata")
+	for _, test := range policytests {
+		var sig bytes.Buffer
+		err := openpgp.DetachSign(&sig, test.entity, bytes.NewReader(data), &packet.Config{DefaultHash: test.hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyring := &gpgKeyring{"gpg", "", &openpgp.EntityList{test.entity}}
+		_, err = keyring.verifyDetachedSig(bytes.NewReader(data), &sig, test.policy)
+		if test.valid && err != nil {
+			t.Error("For", test.name, "expected valid signature got", err)
+		} else if !test.valid && err == nil {
+			t.Error("For", test.name, "expected policy error")
+		}
+	}
+}
@@ -3,40 +3,99 @@ package repository
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"golang.org/x/crypto/openpgp"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type Repository struct {
-	BaseUrl       string
-	SkipGPGVerify bool
-	metaDir       string
-	repoMeta      string
-	repoMetaSig   string
-	signingKey    string
+	Name           string
+	BaseUrl        string
+	KeyPath        string
+	KeyringPath    string
+	Fingerprints   []string
+	DownloadDir    string
+	TrustStore     *TrustStore
+	Policy         Policy
+	SkipGPGVerify  bool
+	CacheDir       string
+	StateDir       string
+	Refresh        bool
+	Offline        bool
+	Replay         bool
+	MaxMetadataAge time.Duration
+	TUFRoot        string
+	metaDir        string
+	repoMeta       string
+	repoMetaSig    string
+	signingKey     string
+	keyring        *gpgKeyring
+	tuf            *tufRepo
 }
 
+// RepoMetadata is a parsed repomd.xml, each data entry is a typed index such
+// as the primary module manifest
 type RepoMetadata struct {
-	Revision string           `xml:"revision"`
-	Manifest ManifestMetadata `xml:"data"`
+	Revision string             `xml:"revision"`
+	Data     []ManifestMetadata `xml:"data"`
+}
+
+// Lookup returns the data entry of type dataType
+func (m RepoMetadata) Lookup(dataType string) (ManifestMetadata, bool) {
+	for _, entry := range m.Data {
+		if entry.RepoType == dataType {
+			return entry, true
+		}
+	}
+	return ManifestMetadata{}, false
+}
+
+// Primary returns the data entry of the primary module manifest
+func (m RepoMetadata) Primary() (ManifestMetadata, error) {
+	entry, ok := m.Lookup(primaryData)
+	if !ok {
+		return ManifestMetadata{}, errors.New("repository metadata has no primary data")
+	}
+	return entry, nil
 }
 
 type ManifestMetadata struct {
 	RepoType     string   `xml:"type,attr"`
-	Checksum     string   `xml:"checksum"`
-	OpenChecksum string   `xml:"open_checksum"`
+	Checksum     Checksum `xml:"checksum"`
+	OpenChecksum Checksum `xml:"open_checksum"`
 	Location     Location `xml:"location"`
 	Timestamp    string   `xml:"timestamp"`
 	Size         int      `xml:"size"`
 	OpenSize     int      `xml:"open_size"`
 }
 
+// primaryData is the repomd.xml data type of the module manifest
+const primaryData = "primary"
+
+// PublicUrl is the public threatresponse LiME repository
+const PublicUrl = "https://threatresponse-lime-modules.s3.amazonaws.com/"
+
+// publicFingerprint is the signing key fingerprint of the public repository
+// shipped with marsho
+// TODO: set to the fingerprint of the published REPO_SIGNING_KEY.asc, until
+// it is set or overridden the public key has to be in the GnuPG keyring
+const publicFingerprint = ""
+
+// PublicFingerprint pins the signing key of the public repository, it
+// defaults to publicFingerprint and may be overridden at build time with
+// -ldflags "-X .../repository.PublicFingerprint=..."
+var PublicFingerprint = publicFingerprint
+
 var netClient *http.Client
 
 func init() {
@@ -45,6 +104,27 @@ func init() {
 	}
 }
 
+// SetHTTPOptions configures the client used for every http repository, an
+// empty proxy falls back to the HTTP_PROXY environment variables
+func SetHTTPOptions(timeout time.Duration, proxy string) error {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	if proxy != "" {
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid proxy url %s: %s", proxy, err))
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	netClient = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+	return nil
+}
+
 func repoMetadata(data []byte) RepoMetadata {
 	var repo RepoMetadata
 	xml.Unmarshal(data, &repo)
@@ -53,43 +133,62 @@ func repoMetadata(data []byte) RepoMetadata {
 
 func DefaultRepository() Repository {
 	return Repository{
-		"https://threatresponse-lime-modules.s3.amazonaws.com/",
-		false,
-		"repodata/",
-		"repomd.xml",
-		"repomd.xml.sig",
-		"REPO_SIGNING_KEY.asc",
+		BaseUrl:       PublicUrl,
+		Fingerprints:  DefaultFingerprints(PublicUrl),
+		SkipGPGVerify: false,
+		CacheDir:      DefaultCacheDir(),
+		StateDir:      DefaultStateDir(),
+		metaDir:       "repodata/",
+		repoMeta:      "repomd.xml",
+		repoMetaSig:   "repomd.xml.sig",
+		signingKey:    "REPO_SIGNING_KEY.asc",
 	}
 }
 
-func (r *Repository) Get(kernVer string) int {
-
-	modules, err := r.Find(kernVer)
-	if err != nil {
-		log.Critical(err)
-		return 0
+// DefaultFingerprints returns the built in signing key fingerprints trusted
+// for the repository at baseUrl
+func DefaultFingerprints(baseUrl string) []string {
+	if baseUrl == PublicUrl && PublicFingerprint != "" {
+		return []string{PublicFingerprint}
 	}
+	return nil
+}
 
-	//TODO: prompt if there are multiple matches
-	//TODO: implement a multi-get function that downloads all matches
-	// Exit if there are multiple matches for a kernel version
-	if len(modules) != 1 {
-		log.Critical(fmt.Sprintf("multiple matches for: %s", kernVer))
-		return 1
-	}
-	log.Debug(fmt.Sprintf("found module matching: %s", kernVer))
+// FetchResult records the outcome of downloading a single module, UpToDate
+// is set when a mirrored module was already present and unchanged and
+// Verified names how the module was verified, gpg or tuf, and is empty when
+// verification is disabled
+type FetchResult struct {
+	Module   Module
+	Path     string
+	UpToDate bool
+	Verified string
+	Err      error
+}
 
-	localPath, err := r.download(modules[0])
-	if err != nil {
-		log.Critical(err)
-		return 0
-	}
-	log.Info(fmt.Sprintf("module downloaded to %s", localPath))
+// Get downloads the module matching kernVer, choose is consulted when more
+// than one module matches and may be nil to refuse ambiguous versions
+func (r *Repository) Get(kernVer string, choose Selector) (FetchResult, error) {
+	g := Group{[]*Repository{r}}
+	return g.Get(kernVer, choose)
+}
 
-	return 1
+// GetAll downloads and verifies every module matching kernVer using at most
+// workers concurrent downloads, results are returned in manifest order
+func (r *Repository) GetAll(kernVer string, workers int) ([]FetchResult, error) {
+	g := Group{[]*Repository{r}}
+	return g.GetAll(kernVer, workers)
 }
 
-func (r *Repository) Find(kernVer string) ([]Module, error) {
+// Filter returns the modules in the repository matching f
+func (r *Repository) Filter(f Filter) ([]Module, error) {
+	g := Group{[]*Repository{r}}
+	return g.Filter(f)
+}
+
+// Find returns the modules in the repository matching f, failing with the
+// closest modules when none match
+func (r *Repository) Find(f Filter) ([]Module, error) {
 
 	var modules []Module
 	manifest, err := r.manifest()
@@ -97,7 +196,7 @@ func (r *Repository) Find(kernVer string) ([]Module, error) {
 		return modules, err
 	}
 
-	modules, err = manifest.find(kernVer)
+	modules, err = manifest.find(f)
 	if err != nil {
 		return modules, err
 	}
@@ -110,6 +209,15 @@ func (r *Repository) List() (Manifest, error) {
 	return manifest, err
 }
 
+// Source names the repository modules were listed by, the configured name
+// when there is one and the base url otherwise
+func (r *Repository) Source() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.BaseUrl
+}
+
 func (r *Repository) manifest() (Manifest, error) {
 	repo, err := r.metadata()
 	if err != nil {
@@ -121,141 +229,620 @@ func (r *Repository) manifest() (Manifest, error) {
 		return Manifest{}, err
 	}
 
+	for i := range manifest.Modules {
+		manifest.Modules[i].Source = r.Source()
+	}
 	return manifest, nil
 }
 
+// metaCache returns the metadata cache for this repository or nil if
+// caching is disabled
+func (r *Repository) metaCache() *cache {
+	if r.CacheDir == "" || r.isLocal() {
+		return nil
+	}
+	return newCache(r.CacheDir, r.BaseUrl)
+}
+
+// metadataFiles holds the raw repository metadata files alongside the
+// parsed repomd.xml, signature and signingKey are nil when unverified
+type metadataFiles struct {
+	repomd     []byte
+	signature  []byte
+	signingKey []byte
+	metadata   RepoMetadata
+}
+
 func (r *Repository) metadata() (RepoMetadata, error) {
-	// fetch repository metadata file
-	var url string
-	url = fmt.Sprintf("%s%s%s", r.BaseUrl, r.metaDir, r.repoMeta)
-	log.Debug(fmt.Sprintf("fetching repo metadata: %s", url))
+	files, err := r.metadataFiles()
+	return files.metadata, err
+}
 
-	resp, err := netClient.Get(url)
+func (r *Repository) metadataFiles() (metadataFiles, error) {
+	c := r.metaCache()
+	if r.offline() && c == nil {
+		return metadataFiles{}, errors.New("offline mode requires a metadata cache directory")
+	}
+
+	// fetch repository metadata file
+	rawMetadata, cached, state, err := r.fetchMetadata(c)
 	if err != nil {
-		return RepoMetadata{},
-			errors.New(fmt.Sprintf("unable to fetch repository metadata: %s", err))
+		return metadataFiles{}, err
 	}
-	defer resp.Body.Close()
-	rawMetadata, err := ioutil.ReadAll(resp.Body)
+	files := metadataFiles{repomd: rawMetadata}
 
-	if r.SkipGPGVerify == false {
-		url = fmt.Sprintf("%s%s", r.BaseUrl, r.signingKey)
-		log.Debug(fmt.Sprintf("fetching repo signing key: %s", url))
-		resp, err = netClient.Get(url)
+	if r.SkipGPGVerify == false && r.TUFRoot != "" {
+		tuf, err := r.loadTUF(time.Now())
 		if err != nil {
-			return RepoMetadata{},
-				errors.New(fmt.Sprintf("error fetching repository signing key: %s", err))
+			return metadataFiles{}, err
 		}
-		defer resp.Body.Close()
-
-		repoKey, err := readKey(resp.Body)
+		err = tuf.verifyTarget(r.metaDir+r.repoMeta, rawMetadata)
 		if err != nil {
-			return RepoMetadata{},
-				errors.New(fmt.Sprintf("error reading repository signing key: %s", err))
+			return metadataFiles{}, err
 		}
-
-		// load user's keyring
-		keyring, err := getDefaultKeyring()
+		r.tuf = tuf
+	} else if r.SkipGPGVerify == false {
+		keyring, keyData, err := r.repoKeyring(c, cached)
 		if err != nil {
-			return RepoMetadata{},
-				errors.New(fmt.Sprintf("error loading user keyring: %s", err))
-		}
-
-		//check if repo key is imported to user keychain
-		//TODO: expand info in error message
-		if !keyring.contains(repoKey) {
-			return RepoMetadata{},
-				errors.New("Repository key not imported in user keychain")
+			return metadataFiles{}, err
 		}
 
 		// fetch detached repository metadata signature
-		url = fmt.Sprintf("%s%s%s", r.BaseUrl, r.metaDir, r.repoMetaSig)
-		log.Debug(fmt.Sprintf("fetching repo metadata signature: %s", url))
-		resp, err = netClient.Get(url)
+		sigData, err := r.metadataFile(c, cached, r.repoMetaSig, r.metaDir+r.repoMetaSig)
 		if err != nil {
-			return RepoMetadata{},
+			return metadataFiles{},
 				errors.New(fmt.Sprintf("error fetching repo metadata signature: %s", err))
 		}
-		defer resp.Body.Close()
 
 		metadataReader := bytes.NewReader(rawMetadata)
-		signer, err := keyring.verifyDetachedSig(metadataReader, resp.Body)
+		signer, err := keyring.verifyDetachedSig(metadataReader, bytes.NewReader(sigData), r.Policy)
 		if err != nil {
-			return RepoMetadata{},
+			return metadataFiles{},
 				errors.New(fmt.Sprintf("error verifying repo metadata signature: %s", err))
 		}
+		if !r.trusts(signer) {
+			return metadataFiles{}, errors.New(fmt.Sprintf(
+				"repo metadata signed by %s which is not a trusted fingerprint", signer.fingerprint(),
+			))
+		}
 		log.Debug(fmt.Sprintf("verified metadata signature against %s", signer.fingerprint()))
+		r.keyring = keyring
+		files.signature = sigData
+		files.signingKey = keyData
+	}
+
+	files.metadata = repoMetadata(rawMetadata)
+	err = r.checkFreshness(files.metadata, time.Now())
+	if err != nil {
+		return metadataFiles{}, err
+	}
+
+	if c != nil {
+		if files.signingKey != nil && r.KeyPath == "" {
+			c.store(r.signingKey, files.signingKey)
+		}
+		if files.signature != nil {
+			c.store(r.repoMetaSig, files.signature)
+		}
+		if !cached {
+			// a signature cached for the previous repomd.xml would otherwise
+			// be paired with unverified metadata on the next verified run
+			if r.SkipGPGVerify {
+				c.remove(r.repoMetaSig)
+			}
+			c.store(r.repoMeta, rawMetadata)
+			c.saveState(state)
+		}
 	}
-	return repoMetadata(rawMetadata), nil
+	return files, nil
+}
+
+// repoKeyring returns the keyring repository metadata is verified against
+// along with the raw repository signing key. A configured KeyPath is trusted
+// directly and a published key is trusted when its fingerprint is pinned or
+// it is in the trust store, without pinned fingerprints the published key
+// may instead be present in the configured keyring or, when none is
+// configured, the GnuPG keyring. Otherwise verification fails closed.
+func (r *Repository) repoKeyring(c *cache, cached bool) (*gpgKeyring, []byte, error) {
+	if r.KeyPath != "" {
+		keyData, err := ioutil.ReadFile(r.KeyPath)
+		if err != nil {
+			return nil, nil,
+				errors.New(fmt.Sprintf("error reading repository signing key: %s", err))
+		}
+		repoKey, err := readKey(bytes.NewReader(keyData))
+		if err != nil {
+			return nil, nil,
+				errors.New(fmt.Sprintf("error reading repository signing key: %s", err))
+		}
+		return &gpgKeyring{"file", r.KeyPath, &openpgp.EntityList{repoKey.key}}, keyData, nil
+	}
+
+	keyData, err := r.metadataFile(c, cached, r.signingKey, r.signingKey)
+	if err != nil {
+		return nil, nil,
+			errors.New(fmt.Sprintf("error fetching repository signing key: %s", err))
+	}
+
+	repoKey, err := readKey(bytes.NewReader(keyData))
+	if err != nil {
+		return nil, nil,
+			errors.New(fmt.Sprintf("error reading repository signing key: %s", err))
+	}
+
+	if r.pinned(repoKey) {
+		log.Debug(fmt.Sprintf("repository signing key %s is pinned", repoKey.fingerprint()))
+		return &gpgKeyring{"pinned", r.BaseUrl + r.signingKey, &openpgp.EntityList{repoKey.key}}, keyData, nil
+	}
+	if len(r.Fingerprints) > 0 {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"repository signing key %s does not match a trusted fingerprint, "+
+				"review and trust it with 'marsho keys fetch -repo %s'",
+			repoKey.fingerprint(), r.BaseUrl,
+		))
+	}
+
+	// without a pin or configured keyring the GnuPG keybox is consulted
+	keyringPath := r.KeyringPath
+	if keyringPath == "" {
+		keyringPath = GnuPGKeyring
+	}
+	keyring, err := getDefaultKeyring(keyringPath)
+	if err != nil && r.KeyringPath == "" {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"repository signing key %s is not trusted, no fingerprint, signing key or keyring is configured for %s "+
+				"and the default 'keyring: gnupg' could not be loaded (%s), import the key into GnuPG, "+
+				"configure a keyring or review and trust it with 'marsho keys fetch -repo %s'",
+			repoKey.fingerprint(), r.BaseUrl, err, r.BaseUrl,
+		))
+	}
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"repository signing key %s is not trusted and keyring %s could not be loaded (%s), "+
+				"review and trust it with 'marsho keys fetch -repo %s'",
+			repoKey.fingerprint(), r.KeyringPath, err, r.BaseUrl,
+		))
+	}
+
+	//check if repo key is imported to user keychain
+	if !keyring.contains(repoKey) {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"repository signing key %s is not trusted or imported in %s, "+
+				"review and trust it with 'marsho keys fetch -repo %s'",
+			repoKey.fingerprint(), keyring.defaultKeyring, r.BaseUrl,
+		))
+	}
+
+	return keyring, keyData, nil
+}
+
+// SigningKey returns the repository signing key without trusting it, so it
+// can be reviewed before being imported into a TrustStore
+func (r *Repository) SigningKey() ([]byte, error) {
+	if r.KeyPath != "" {
+		return ioutil.ReadFile(r.KeyPath)
+	}
+	return r.metadataFile(nil, false, r.signingKey, r.signingKey)
+}
+
+// fetchMetadata returns the raw repomd.xml, revalidating any cached copy with
+// ETag and If-Modified-Since, cached is true when the cached copy is current
+func (r *Repository) fetchMetadata(c *cache) ([]byte, bool, cacheState, error) {
+	var state cacheState
+	var cachedMetadata []byte
+	if c != nil {
+		state = c.state()
+		cachedMetadata, _ = c.read(r.repoMeta)
+	}
+
+	if r.isLocal() {
+		rawMetadata, err := r.fetchBytes(r.metaDir + r.repoMeta)
+		if err != nil {
+			return nil, false, state,
+				errors.New(fmt.Sprintf("unable to read repository metadata: %s", err))
+		}
+		return rawMetadata, false, state, nil
+	}
+	if r.Offline {
+		if cachedMetadata == nil {
+			return nil, false, state, errors.New(
+				fmt.Sprintf("no cached repository metadata for %s, run once without -offline", r.BaseUrl),
+			)
+		}
+		log.Debug(fmt.Sprintf("using cached repo metadata for %s", r.BaseUrl))
+		return cachedMetadata, true, state, nil
+	}
+	if r.Refresh {
+		cachedMetadata = nil
+	}
+
+	url := fmt.Sprintf("%s%s%s", r.BaseUrl, r.metaDir, r.repoMeta)
+	log.Debug(fmt.Sprintf("fetching repo metadata: %s", url))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, state,
+			errors.New(fmt.Sprintf("unable to fetch repository metadata: %s", err))
+	}
+	if cachedMetadata != nil {
+		if state.ETag != "" {
+			req.Header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			req.Header.Set("If-Modified-Since", state.LastModified)
+		}
+	}
+
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, false, state,
+			errors.New(fmt.Sprintf("unable to fetch repository metadata: %s", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cachedMetadata != nil {
+		log.Debug("repo metadata not modified, using cache")
+		return cachedMetadata, true, state, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, state,
+			errors.New(fmt.Sprintf("unable to fetch repository metadata: %s", resp.Status))
+	}
+
+	rawMetadata, err := readLimited(resp.Body, maxMetadataSize)
+	if err != nil {
+		return nil, false, state,
+			errors.New(fmt.Sprintf("unable to read repository metadata: %s", err))
+	}
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+
+	cached := cachedMetadata != nil && bytes.Equal(rawMetadata, cachedMetadata)
+	return rawMetadata, cached, state, nil
+}
+
+// metadataFile returns a file belonging to repomd.xml, preferring the cached
+// copy when the cached repomd.xml is still current
+func (r *Repository) metadataFile(c *cache, cached bool, name string, href string) ([]byte, error) {
+	if c != nil && cached {
+		data, err := c.read(name)
+		if err == nil {
+			log.Debug(fmt.Sprintf("using cached %s", name))
+			return data, nil
+		}
+	}
+	if r.offline() {
+		return nil, errors.New(fmt.Sprintf("%s is not cached", name))
+	}
+
+	return r.fetchBytes(href)
 }
 
 func (r *Repository) fetchManifest(repo RepoMetadata) (Manifest, error) {
-	//Download manifest from repository
-	url := fmt.Sprintf("%s%s", r.BaseUrl, repo.Manifest.Location.Href)
-	log.Debug(fmt.Sprintf("fetching manifest: %s", url))
-	resp, err := netClient.Get(url)
+	primary, err := repo.Primary()
 	if err != nil {
+		return Manifest{}, err
+	}
+
+	c := r.metaCache()
+	if c != nil && !r.Refresh && c.state().Revision == repo.Revision {
+		data, err := c.read(manifestCacheFile)
+		if err == nil {
+			valid, _, err := primary.OpenChecksum.verify(data)
+			if err == nil && valid {
+				log.Debug(fmt.Sprintf("using cached manifest for revision %s", repo.Revision))
+				return moduleManifest(data), nil
+			}
+		}
+	}
+	if r.offline() {
 		return Manifest{}, errors.New(
-			fmt.Sprintf("unable to fetch repository manifest: %s", err),
+			fmt.Sprintf("no cached manifest for revision %s of %s", repo.Revision, r.BaseUrl),
+		)
+	}
+
+	_, data, err := r.downloadData(primary)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if c != nil {
+		c.store(manifestCacheFile, data)
+		state := c.state()
+		state.Revision = repo.Revision
+		c.saveState(state)
+	}
+
+	// create manifest object
+	//TODO: add error handling
+	return moduleManifest(data), nil
+}
+
+// downloadData returns the verified gzipped and decompressed data of a
+// repomd.xml entry
+func (r *Repository) downloadData(entry ManifestMetadata) ([]byte, []byte, error) {
+	log.Debug(fmt.Sprintf("fetching %s data: %s", entry.RepoType, entry.Location.Href))
+	limit, err := sizeLimit(entry.Size, maxMetadataSize)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s data %s", entry.RepoType, err))
+	}
+	openLimit, err := sizeLimit(entry.OpenSize, maxMetadataSize)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s data %s", entry.RepoType, err))
+	}
+	gzBody, err := r.fetchLimited(entry.Location.Href, limit)
+	if err == nil {
+		err = checkSize(gzBody, entry.Size)
+	}
+	if err != nil {
+		return nil, nil, errors.New(
+			fmt.Sprintf("unable to fetch %s data: %s", entry.RepoType, err),
 		)
 	}
-	defer resp.Body.Close()
 
 	// verify gzipped file checksum
-	gzBody, err := ioutil.ReadAll(resp.Body)
-	valid, calcSum := sha256sum(gzBody, repo.Manifest.Checksum)
+	valid, calcSum, err := entry.Checksum.verify(gzBody)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s data %s", entry.RepoType, err))
+	}
 	if valid == false {
-		return Manifest{},
+		return nil, nil,
 			errors.New(
 				fmt.Sprintf(
-					"manifest checksum mismatch expected: %s found: %s",
-					repo.Manifest.OpenChecksum, calcSum,
+					"%s data checksum mismatch expected: %s found: %s",
+					entry.RepoType, entry.Checksum, calcSum,
 				),
 			)
 	}
 
-	//Unzip manifest
-	//TODO: check for errors
-	buf := bytes.NewBuffer(gzBody)
-	reader, err := gzip.NewReader(buf)
+	// unzip the data, never inflating past the advertised open size so
+	// a small compressed body cannot exhaust memory
+	reader, err := gzip.NewReader(bytes.NewReader(gzBody))
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to decompress %s data: %s", entry.RepoType, err))
+	}
 	defer reader.Close()
-	data, err := ioutil.ReadAll(reader)
+	data, err := readLimited(reader, openLimit)
+	if err == nil {
+		err = checkSize(data, entry.OpenSize)
+	}
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("unable to decompress %s data: %s", entry.RepoType, err))
+	}
 
-	// verify manifest open checksum
-	valid, calcSum = sha256sum(data, repo.Manifest.OpenChecksum)
+	// verify data open checksum
+	valid, calcSum, err = entry.OpenChecksum.verify(data)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s data %s", entry.RepoType, err))
+	}
 	if valid == false {
-		return Manifest{},
+		return nil, nil,
 			errors.New(
 				fmt.Sprintf(
-					"manifest open checksum mismatch expected: %s found: %s",
-					repo.Manifest.OpenChecksum, calcSum,
+					"%s data open checksum mismatch expected: %s found: %s",
+					entry.RepoType, entry.OpenChecksum, calcSum,
 				),
 			)
 	}
 
-	// create manifest object
-	//TODO: add error handling
-	return moduleManifest(data), nil
+	return gzBody, data, nil
+}
+
+// Index returns the decompressed data of the repomd.xml entry of type
+// dataType, such as an additional per distribution index, ok is false when
+// the repository does not publish it
+func (r *Repository) Index(dataType string) ([]byte, bool, error) {
+	repo, err := r.metadata()
+	if err != nil {
+		return nil, false, err
+	}
+	entry, ok := repo.Lookup(dataType)
+	if !ok {
+		return nil, false, nil
+	}
+
+	// the type names the cache file so only plain names are cached
+	cacheName := dataType + "-index.xml"
+	c := r.metaCache()
+	if strings.ContainsAny(dataType, `/\`) {
+		c = nil
+	}
+	if c != nil && !r.Refresh {
+		data, err := c.read(cacheName)
+		if err == nil {
+			valid, _, err := entry.OpenChecksum.verify(data)
+			if err == nil && valid {
+				log.Debug(fmt.Sprintf("using cached %s index", dataType))
+				return data, true, nil
+			}
+		}
+	}
+	if r.offline() {
+		return nil, false, errors.New(
+			fmt.Sprintf("no cached %s index for revision %s of %s", dataType, repo.Revision, r.BaseUrl),
+		)
+	}
+
+	_, data, err := r.downloadData(entry)
+	if err != nil {
+		return nil, false, err
+	}
+	if c != nil {
+		c.store(cacheName, data)
+	}
+	return data, true, nil
 }
 
+// verification names how modules from the repository are verified
+func (r *Repository) verification() string {
+	switch {
+	case r.SkipGPGVerify:
+		return ""
+	case r.TUFRoot != "":
+		return "tuf"
+	}
+	return "gpg"
+}
+
+// offline reports whether metadata must come from the cache, local
+// repositories never need the network so they are always available
+func (r *Repository) offline() bool {
+	return r.Offline && !r.isLocal()
+}
+
+// trusts reports whether signer is acceptable, any key verifying against the
+// keyring is trusted unless fingerprints are configured
+func (r *Repository) trusts(signer *gpgKey) bool {
+	return len(r.Fingerprints) == 0 || r.pinned(signer)
+}
+
+// pinned reports whether key matches a configured fingerprint or is held in
+// the trust store for this repository
+func (r *Repository) pinned(key *gpgKey) bool {
+	for _, fingerprint := range r.Fingerprints {
+		if normalizeFingerprint(fingerprint) == key.fingerprint() {
+			return true
+		}
+	}
+	return r.TrustStore.trusts(key, r.BaseUrl)
+}
+
+// download fetches mod into DownloadDir, the module name comes from the
+// manifest and must be a plain file name so it cannot escape the directory
 func (r *Repository) download(mod Module) (string, error) {
-	var url string
-	url = fmt.Sprintf("%s%s", r.BaseUrl, mod.Location.Href)
-	log.Debug(fmt.Sprintf("downloading module from: %s", url))
-	resp, err := netClient.Get(url)
+	if !validModuleName(mod.Name) {
+		return "", errors.New(fmt.Sprintf("invalid module name %q", mod.Name))
+	}
+	localPath := filepath.Join(r.DownloadDir, mod.Name)
+	_, err := r.downloadTo(mod, localPath)
 	if err != nil {
 		return "", err
 	}
-	log.Debug(fmt.Sprintf("get module returned %s", resp.Status))
-	defer resp.Body.Close()
+	return localPath, nil
+}
+
+// validModuleName reports whether name is a single path element
+func validModuleName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return filepath.Base(name) == name && !strings.ContainsAny(name, `/\`)
+}
 
-	modFile, err := os.Create(mod.Name)
+// downloadTo downloads and verifies mod into localPath, returning the module
+// signature when it was verified
+func (r *Repository) downloadTo(mod Module, localPath string) ([]byte, error) {
+	log.Debug(fmt.Sprintf("downloading module from: %s", mod.Location.Href))
+	body, err := r.open(mod.Location.Href)
 	if err != nil {
-		return "", err
+		return nil, errors.New(
+			fmt.Sprintf("unable to fetch module %s: %s", mod.Name, err),
+		)
+	}
+	defer body.Close()
+
+	// download to a temporary name so a failed download never replaces
+	// an existing module
+	hash, err := mod.Checksum.newHash()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("module %s %s", mod.Name, err))
+	}
+
+	partPath := localPath + ".part"
+	modFile, err := os.Create(partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// hash the module as it is written so a truncated or tampered
+	// download never needs to be read back for the checksum
+	n, err := io.Copy(io.MultiWriter(modFile, hash), io.LimitReader(body, maxModuleSize+1))
+	modFile.Close()
+	if err == nil && n > maxModuleSize {
+		err = errors.New(fmt.Sprintf("size exceeds limit of %d bytes", int64(maxModuleSize)))
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return nil, errors.New(
+			fmt.Sprintf("error downloading module %s: %s", mod.Name, err),
+		)
 	}
 
+	sum := hash.Sum(nil)
+	calcSum := hex.EncodeToString(sum)
+	if !mod.Checksum.matches(sum) {
+		os.Remove(partPath)
+		return nil, errors.New(
+			fmt.Sprintf(
+				"module checksum mismatch for %s expected: %s found: %s",
+				mod.Name, mod.Checksum, calcSum,
+			),
+		)
+	}
+	log.Debug(fmt.Sprintf("verified module checksum %s", calcSum))
+
+	var sig []byte
+	if r.SkipGPGVerify == false && r.tuf != nil {
+		err = r.verifyTUFModule(mod, partPath)
+		if err != nil {
+			os.Remove(partPath)
+			return nil, err
+		}
+	} else if r.SkipGPGVerify == false {
+		sig, err = r.verifyModule(mod, partPath)
+		if err != nil {
+			os.Remove(partPath)
+			return nil, err
+		}
+	}
+
+	err = os.Rename(partPath, localPath)
+	if err != nil {
+		os.Remove(partPath)
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+// verifyTUFModule checks the module at localPath against its TUF target
+func (r *Repository) verifyTUFModule(mod Module, localPath string) error {
+	data, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	return r.tuf.verifyTarget(mod.Location.Href, data)
+}
+
+// verifyModule checks the detached signature of the module at localPath and
+// returns the signature
+func (r *Repository) verifyModule(mod Module, localPath string) ([]byte, error) {
+	if r.keyring == nil {
+		return nil, errors.New("repository keyring not loaded, cannot verify module signature")
+	}
+	if mod.Signature.Href == "" {
+		return nil, errors.New(fmt.Sprintf("module %s has no signature", mod.Name))
+	}
+
+	// fetch detached module signature
+	log.Debug(fmt.Sprintf("fetching module signature: %s", mod.Signature.Href))
+	sig, err := r.fetchBytes(mod.Signature.Href)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error fetching module signature: %s", err))
+	}
+
+	modFile, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
 	defer modFile.Close()
-	io.Copy(modFile, resp.Body)
 
-	return mod.Name, nil
+	signer, err := r.keyring.verifyDetachedSig(modFile, bytes.NewReader(sig), r.Policy)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("error verifying module signature for %s: %s", mod.Name, err),
+		)
+	}
+	log.Debug(fmt.Sprintf("verified module signature against %s", signer.fingerprint()))
+
+	return sig, nil
 }
@@ -1,8 +1,18 @@
 package repository
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"golang.org/x/crypto/openpgp"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -51,6 +61,10 @@ var repomdtest = repomd{
 
 func TestRepoMetadata(t *testing.T) {
 	metadata := repoMetadata(*repomdtest.data)
+	primary, err := metadata.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if metadata.Revision != repomdtest.revision {
 		t.Error(
@@ -59,53 +73,402 @@ func TestRepoMetadata(t *testing.T) {
 			"got", metadata.Revision,
 		)
 	}
-	if metadata.Manifest.RepoType != repomdtest.dataType {
+	if primary.RepoType != repomdtest.dataType {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected type", repomdtest.dataType,
-			"got", metadata.Manifest.RepoType,
+			"got", primary.RepoType,
 		)
 	}
-	if metadata.Manifest.Checksum != repomdtest.dataChecksum {
+	if primary.Checksum.Value != repomdtest.dataChecksum {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected checksum", repomdtest.dataChecksum,
-			"got", metadata.Manifest.Checksum,
+			"got", primary.Checksum.Value,
 		)
 	}
-	if metadata.Manifest.OpenChecksum != repomdtest.dataOpenChecksum {
+	if primary.OpenChecksum.Value != repomdtest.dataOpenChecksum {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected open checksum", repomdtest.dataOpenChecksum,
-			"got", metadata.Manifest.OpenChecksum,
+			"got", primary.OpenChecksum.Value,
 		)
 	}
-	if metadata.Manifest.Location.Href != repomdtest.dataLocation {
+	if primary.Location.Href != repomdtest.dataLocation {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected location", repomdtest.dataLocation,
-			"got", metadata.Manifest.Location.Href,
+			"got", primary.Location.Href,
 		)
 	}
-	if metadata.Manifest.Timestamp != repomdtest.dataTimestamp {
+	if primary.Timestamp != repomdtest.dataTimestamp {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected", repomdtest.dataTimestamp,
-			"got", metadata.Manifest.Timestamp,
+			"got", primary.Timestamp,
 		)
 	}
-	if metadata.Manifest.Size != repomdtest.dataSize {
+	if primary.Size != repomdtest.dataSize {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected", repomdtest.dataSize,
-			"got", metadata.Manifest.Size,
+			"got", primary.Size,
 		)
 	}
-	if metadata.Manifest.OpenSize != repomdtest.dataOpenSize {
+	if primary.OpenSize != repomdtest.dataOpenSize {
 		t.Error(
 			"For\n", string(*repomdtest.data),
 			"expected", repomdtest.dataOpenSize,
-			"got", metadata.Manifest.OpenSize,
+			"got", primary.OpenSize,
 		)
 	}
 }
+
+type downloadTest struct {
+	name     string
+	body     []byte
+	checksum string
+	signed   []byte
+	valid    bool
+}
+
+func testModule(name string, checksum string) Module {
+	return Module{
+		Name:      name,
+		Checksum:  Checksum{Value: checksum},
+		Location:  Location{"modules/" + name},
+		Signature: Location{"modules/" + name + ".sig"},
+	}
+}
+
+// downloadFixture serves signed modules for downloadtests from a local
+// http server and changes into a scratch directory for the downloads
+func downloadFixture(t *testing.T) (Repository, []downloadTest, func()) {
+	entity, err := openpgp.NewEntity("marsho test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := []byte("lime kernel module")
+	sum := sha256.Sum256(module)
+	checksum := hex.EncodeToString(sum[:])
+
+	downloadtests := []downloadTest{
+		{"valid.ko", module, checksum, module, true},
+		{"truncated.ko", module[:4], checksum, module[:4], false},
+		{"badsig.ko", module, checksum, []byte("other data"), false},
+	}
+
+	files := map[string][]byte{}
+	for _, input := range downloadtests {
+		var sig bytes.Buffer
+		err := openpgp.DetachSign(&sig, entity, bytes.NewReader(input.signed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files["/modules/"+input.name] = input.body
+		files["/modules/"+input.name+".sig"] = sig.Bytes()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			data, ok := files[req.URL.Path]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(data)
+		},
+	))
+
+	workDir, err := ioutil.TempDir("", "marsho-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	os.Chdir(workDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = server.URL + "/"
+	r.keyring = &gpgKeyring{"gpg", "", &openpgp.EntityList{entity}}
+
+	return r, downloadtests, func() {
+		os.Chdir(cwd)
+		os.RemoveAll(workDir)
+		server.Close()
+	}
+}
+
+func TestDownload(t *testing.T) {
+	r, downloadtests, cleanup := downloadFixture(t)
+	defer cleanup()
+
+	for _, input := range downloadtests {
+		_, err := r.download(testModule(input.name, input.checksum))
+		valid := err == nil
+		if valid != input.valid {
+			t.Error(
+				"For", input.name,
+				"expected valid?", input.valid,
+				"got valid?", valid,
+				"with err", err,
+			)
+		}
+		_, statErr := os.Stat(input.name)
+		if valid == false && statErr == nil {
+			t.Error(
+				"For", input.name,
+				"expected failed download to be removed",
+			)
+		}
+	}
+}
+
+var moduleNameTests = []struct {
+	name  string
+	valid bool
+}{
+	{"lime-4.2.0-17-generic.ko", true},
+	{"../../.bashrc", false},
+	{"modules/lime.ko", false},
+	{"..", false},
+	{"", false},
+	{`..\lime.ko`, false},
+}
+
+func TestValidModuleName(t *testing.T) {
+	for _, input := range moduleNameTests {
+		if validModuleName(input.name) != input.valid {
+			t.Error("For", input.name, "expected valid?", input.valid)
+		}
+	}
+
+	r := DefaultRepository()
+	r.SkipGPGVerify = true
+	_, err := r.download(testModule("../escape.ko", ""))
+	if err == nil || !strings.Contains(err.Error(), "invalid module name") {
+		t.Error("expected invalid module name error got", err)
+	}
+}
+
+func TestDownloadAll(t *testing.T) {
+	r, downloadtests, cleanup := downloadFixture(t)
+	defer cleanup()
+
+	var modules []Module
+	for _, input := range downloadtests {
+		modules = append(modules, testModule(input.name, input.checksum))
+	}
+
+	g := Group{[]*Repository{&r}}
+	results := g.fetchAll([]Manifest{{Modules: modules}}, modules, 2)
+	if len(results) != len(downloadtests) {
+		t.Fatal("expected", len(downloadtests), "results got", len(results))
+	}
+	for i, input := range downloadtests {
+		if results[i].Module.Name != input.name {
+			t.Error("For result", i, "expected module", input.name, "got", results[i].Module.Name)
+		}
+		valid := results[i].Err == nil
+		if valid != input.valid {
+			t.Error(
+				"For", input.name,
+				"expected valid?", input.valid,
+				"got valid?", valid,
+				"with err", results[i].Err,
+			)
+		}
+		if valid && results[i].Verified != "gpg" {
+			t.Error("For", input.name, "expected gpg verification got", results[i].Verified)
+		}
+	}
+}
+
+func TestPinnedFingerprints(t *testing.T) {
+	dir, entity, _ := signedBuildFixture(t)
+	defer os.RemoveAll(dir)
+	fingerprint := (&gpgKey{entity}).fingerprint()
+
+	var pinnedTests = []struct {
+		fingerprints []string
+		valid        bool
+	}{
+		{[]string{fingerprint}, true},
+		{[]string{"0x" + fingerprint[:20] + " " + fingerprint[20:]}, true},
+		{[]string{"0123456789ABCDEF0123456789ABCDEF01234567", fingerprint}, true},
+		{[]string{"0123456789ABCDEF0123456789ABCDEF01234567"}, false},
+	}
+
+	for _, test := range pinnedTests {
+		r := DefaultRepository()
+		r.BaseUrl = dir + "/"
+		r.CacheDir = ""
+		r.Fingerprints = test.fingerprints
+		// an unreadable keyring proves GnuPG is never consulted
+		r.KeyringPath = filepath.Join(dir, "missing-pubring.gpg")
+
+		_, err := r.List()
+		if test.valid && err != nil {
+			t.Error("For", test.fingerprints, "expected verified metadata got", err)
+		} else if !test.valid && err == nil {
+			t.Error("For", test.fingerprints, "expected untrusted key error")
+		}
+	}
+
+	// without a pin or keyring the GnuPG keyring is consulted and
+	// verification fails closed when it cannot be loaded
+	gnupgDir, err := ioutil.TempDir("", "marsho-gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gnupgDir)
+	os.Setenv("GNUPGHOME", gnupgDir)
+	defer os.Unsetenv("GNUPGHOME")
+
+	r := DefaultRepository()
+	r.BaseUrl = dir + "/"
+	r.CacheDir = ""
+	r.Fingerprints = nil
+	_, err = r.List()
+	if err == nil || !strings.Contains(err.Error(), "'keyring: gnupg'") {
+		t.Error("expected untrusted key error without a keyring got", err)
+	}
+
+	var pubring bytes.Buffer
+	entity.Serialize(&pubring)
+	ioutil.WriteFile(filepath.Join(gnupgDir, "pubring.gpg"), pubring.Bytes(), 0600)
+	_, err = r.List()
+	if err != nil {
+		t.Error("expected metadata verified against the GnuPG keyring got", err)
+	}
+}
+
+func TestDefaultRepositoryPin(t *testing.T) {
+	defer func(fingerprint string) { PublicFingerprint = fingerprint }(PublicFingerprint)
+
+	PublicFingerprint = "0123456789ABCDEF0123456789ABCDEF01234567"
+	r := DefaultRepository()
+	if len(r.Fingerprints) != 1 || r.Fingerprints[0] != PublicFingerprint {
+		t.Error("expected the public repository to be pinned got", r.Fingerprints)
+	}
+	if pins := DefaultFingerprints("https://lime-mirror.example.internal/"); len(pins) != 0 {
+		t.Error("expected no built in pin for another repository got", pins)
+	}
+
+	// the shipped default must pin the public repository
+	PublicFingerprint = publicFingerprint
+	r = DefaultRepository()
+	if len(r.Fingerprints) != 1 || r.Fingerprints[0] == "" {
+		t.Skip("no built in public repository fingerprint, set publicFingerprint")
+	}
+}
+
+type sizeTest struct {
+	name     string
+	size     int
+	openSize int
+	valid    bool
+}
+
+func TestManifestSizes(t *testing.T) {
+	// a megabyte of zeros compresses to about a kilobyte
+	data := make([]byte, 1<<20)
+	var gzBuf bytes.Buffer
+	writer := gzip.NewWriter(&gzBuf)
+	writer.Write(data)
+	writer.Close()
+	gzData := gzBuf.Bytes()
+
+	repoDir := writeTestRepo(t, map[string][]byte{"/repodata/primary.xml.gz": gzData})
+	defer os.RemoveAll(repoDir)
+	r := DefaultRepository()
+	r.BaseUrl = repoDir + "/"
+
+	sizetests := []sizeTest{
+		{"advertised sizes", len(gzData), len(data), true},
+		{"sizes not advertised", 0, 0, true},
+		{"oversize body", len(gzData) - 1, len(data), false},
+		{"undersize body", len(gzData) + 1, len(data), false},
+		{"decompression bomb", len(gzData), 1024, false},
+		{"undersize open body", len(gzData), len(data) + 1, false},
+		{"above hard limit", maxMetadataSize + 1, len(data), false},
+	}
+	for _, input := range sizetests {
+		entry := ManifestMetadata{
+			RepoType:     "primary",
+			Checksum:     sha256Checksum(gzData),
+			OpenChecksum: sha256Checksum(data),
+			Location:     Location{"repodata/primary.xml.gz"},
+			Size:         input.size,
+			OpenSize:     input.openSize,
+		}
+		_, _, err := r.downloadData(entry)
+		if (err == nil) != input.valid {
+			t.Error("For", input.name, "expected valid?", input.valid, "got err", err)
+		}
+	}
+}
+
+// testDataEntry gzips data into files and returns its repomd.xml entry
+func testDataEntry(files map[string][]byte, dataType string, data []byte) ManifestMetadata {
+	var gzBuf bytes.Buffer
+	writer := gzip.NewWriter(&gzBuf)
+	writer.Write(data)
+	writer.Close()
+
+	entry := ManifestMetadata{
+		RepoType:     dataType,
+		Checksum:     sha256Checksum(gzBuf.Bytes()),
+		OpenChecksum: sha256Checksum(data),
+		Location:     Location{"repodata/" + dataType + ".xml.gz"},
+		Timestamp:    "1487818901",
+		Size:         gzBuf.Len(),
+		OpenSize:     len(data),
+	}
+	files["/"+entry.Location.Href] = gzBuf.Bytes()
+	return entry
+}
+
+func TestMultipleData(t *testing.T) {
+	files := map[string][]byte{}
+	distro := []byte("<distros><distro>ubuntu</distro></distros>")
+	repomd, err := xml.Marshal(repomdXML{
+		Revision: "1487818901",
+		Data: []ManifestMetadata{
+			testDataEntry(files, "filelists", []byte("<filelists/>")),
+			testDataEntry(files, "primary", unzippedManifestData),
+			testDataEntry(files, "distro", distro),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["/repodata/repomd.xml"] = repomd
+	repoDir := writeTestRepo(t, files)
+	defer os.RemoveAll(repoDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = repoDir + "/"
+	r.SkipGPGVerify = true
+
+	manifest, err := r.List()
+	if err != nil || len(manifest.Modules) != 1 {
+		t.Error("expected the primary manifest to be chosen got", manifest, err)
+	}
+
+	data, ok, err := r.Index("distro")
+	if err != nil || !ok || !bytes.Equal(data, distro) {
+		t.Error("expected distro index", string(distro), "got", string(data), ok, err)
+	}
+	_, ok, err = r.Index("other")
+	if err != nil || ok {
+		t.Error("expected missing index to be reported got", ok, err)
+	}
+
+	metadata := repoMetadata(repomd)
+	metadata.Data = metadata.Data[:1]
+	_, err = r.fetchManifest(metadata)
+	if err == nil {
+		t.Error("expected error for metadata without primary data")
+	}
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// contentTypes covers the repository files mime.TypeByExtension does not
+// know about, unknown types are served as binary rather than sniffed
+var contentTypes = map[string]string{
+	".asc": "application/pgp-keys",
+	".gz":  "application/gzip",
+	".ko":  "application/octet-stream",
+	".sig": "application/pgp-signature",
+	".xml": "application/xml",
+}
+
+type repoServer struct {
+	root string
+}
+
+// FileServer serves a repository directory in the layout Repository reads,
+// supporting ETag and Range requests and logging every request. Hidden files
+// and symlinks leading outside dir are never served.
+func FileServer(dir string) http.Handler {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		root = dir
+	}
+	return logRequests(&repoServer{root})
+}
+
+func (s *repoServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + req.URL.Path)
+	localPath, ok := s.resolve(name)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		// directory listings are never served
+		http.NotFound(w, req)
+		return
+	}
+
+	contentType, ok := contentTypes[filepath.Ext(name)]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+	// ServeContent handles Range, If-Range, If-None-Match and
+	// If-Modified-Since against the headers set above
+	http.ServeContent(w, req, name, info.ModTime(), file)
+}
+
+// resolve maps a cleaned request path to a file below the root, refusing
+// hidden path segments such as .git or in progress .tmp files and symlinks
+// whose target is outside the root
+func (s *repoServer) resolve(name string) (string, bool) {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+
+	localPath, err := filepath.EvalSymlinks(filepath.Join(s.root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.root, localPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Warning(fmt.Sprintf("refusing to serve %s, it resolves outside %s", name, s.root))
+		return "", false
+	}
+	return localPath, true
+}
+
+// statusRecorder captures the status and size of a response for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.size += n
+	return n, err
+}
+
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{w, http.StatusOK, 0}
+		handler.ServeHTTP(recorder, req)
+		log.Info(fmt.Sprintf(
+			"%s %s %s %d %d %s",
+			req.RemoteAddr, req.Method, req.URL.Path,
+			recorder.status, recorder.size, time.Since(start),
+		))
+	})
+}
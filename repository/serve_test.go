@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type serveTest struct {
+	path    string
+	headers map[string]string
+	status  int
+}
+
+func TestFileServer(t *testing.T) {
+	dir := writeTestRepo(t, testRepoFiles(t, unzippedManifestData))
+	defer os.RemoveAll(dir)
+
+	outside, err := ioutil.TempDir("", "marsho-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	ioutil.WriteFile(filepath.Join(outside, "secret.ko"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join(outside, "secret.ko"), filepath.Join(dir, "escape.ko"))
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+	os.Symlink(filepath.Join(dir, "repodata", "repomd.xml"), filepath.Join(dir, "inside.xml"))
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".git", "config"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "repodata", ".repomd.xml.tmp"), []byte("secret"), 0644)
+
+	server := httptest.NewServer(FileServer(dir))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/repodata/repomd.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header on repomd.xml")
+	}
+
+	servetests := []serveTest{
+		{"/repodata/repomd.xml", nil, http.StatusOK},
+		{"/repodata/repomd.xml", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"/repodata/repomd.xml", map[string]string{"Range": "bytes=0-9"}, http.StatusPartialContent},
+		{"/repodata/", nil, http.StatusNotFound},
+		{"/repodata/../../etc/passwd", nil, http.StatusNotFound},
+		{"/missing.ko", nil, http.StatusNotFound},
+		{"/.git/config", nil, http.StatusNotFound},
+		{"/repodata/.repomd.xml.tmp", nil, http.StatusNotFound},
+		{"/escape.ko", nil, http.StatusNotFound},
+		{"/escape/secret.ko", nil, http.StatusNotFound},
+		{"/inside.xml", nil, http.StatusOK},
+	}
+
+	for _, input := range servetests {
+		req, _ := http.NewRequest("GET", server.URL+input.path, nil)
+		for key, value := range input.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != input.status {
+			t.Error(
+				"For", input.path, input.headers,
+				"expected status", input.status,
+				"got", resp.StatusCode,
+			)
+		}
+	}
+
+	// the served repository must be readable by a client
+	r := DefaultRepository()
+	r.BaseUrl = server.URL + "/"
+	r.SkipGPGVerify = true
+	r.CacheDir = ""
+	manifest, err := r.List()
+	if err != nil || len(manifest.Modules) != 1 {
+		t.Error("expected 1 module got", len(manifest.Modules), "with err", err)
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const revisionStateFile = "revision.json"
+
+// revisionState records the highest repomd.xml revision accepted from a
+// repository, it is kept apart from the cache so -refresh or clearing the
+// cache cannot be used to roll a repository back
+type revisionState struct {
+	Revision string    `json:"revision"`
+	Seen     time.Time `json:"seen"`
+}
+
+// DefaultStateDir returns the per-user marsho state directory,
+// $XDG_STATE_HOME/marsho or ~/.local/state/marsho, or an empty string if it
+// cannot be determined
+func DefaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "marsho")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "marsho")
+}
+
+// statePath returns the path of a state file for this repository
+func (r *Repository) statePath(name string) string {
+	sum := sha256.Sum256([]byte(r.BaseUrl))
+	return filepath.Join(r.StateDir, hex.EncodeToString(sum[:8]), name)
+}
+
+func (r *Repository) revisionPath() string {
+	return r.statePath(revisionStateFile)
+}
+
+// checkFreshness refuses metadata older than the highest revision seen
+// from this repository or, when MaxMetadataAge is set, with a timestamp
+// older than that. Replay disables both checks for forensic replays of
+// archived repositories. Revisions are only recorded from verified metadata.
+func (r *Repository) checkFreshness(metadata RepoMetadata, now time.Time) error {
+	if r.Replay {
+		log.Warning(fmt.Sprintf("replay mode, accepting %s revision %s without rollback checks",
+			r.Source(), metadata.Revision))
+		return nil
+	}
+
+	if r.MaxMetadataAge > 0 {
+		primary, err := metadata.Primary()
+		if err != nil {
+			return err
+		}
+		timestamp, err := strconv.ParseFloat(primary.Timestamp, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid repo metadata timestamp %q", primary.Timestamp))
+		}
+		created := time.Unix(int64(timestamp), 0)
+		if now.Sub(created) > r.MaxMetadataAge {
+			return errors.New(fmt.Sprintf(
+				"repo metadata from %s is older than the maximum age of %s, "+
+					"the repository may be frozen, use -replay to accept it",
+				created.UTC().Format(time.RFC3339), r.MaxMetadataAge,
+			))
+		}
+	}
+
+	if r.StateDir == "" {
+		return nil
+	}
+	state, err := r.revisionState()
+	if err != nil {
+		return err
+	}
+	if state.Revision != "" && compareVersions(metadata.Revision, state.Revision) < 0 {
+		return errors.New(fmt.Sprintf(
+			"repo metadata revision %s is older than revision %s seen on %s, "+
+				"the repository may have been rolled back, use -replay to accept it",
+			metadata.Revision, state.Revision, state.Seen.UTC().Format("2006-01-02"),
+		))
+	}
+	// only verified metadata is recorded, an unverified revision could
+	// otherwise make every later verified revision look like a rollback
+	if compareVersions(metadata.Revision, state.Revision) > 0 && !r.SkipGPGVerify {
+		r.saveRevision(revisionState{metadata.Revision, now})
+	}
+	return nil
+}
+
+func (r *Repository) revisionState() (revisionState, error) {
+	var state revisionState
+	data, err := ioutil.ReadFile(r.revisionPath())
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return state, errors.New(fmt.Sprintf("error reading %s: %s", r.revisionPath(), err))
+	}
+	return state, nil
+}
+
+// saveRevision records a newly accepted revision, failures are logged since
+// they only weaken later rollback checks
+func (r *Repository) saveRevision(state revisionState) {
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.revisionPath()), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomic(r.revisionPath(), data, 0600)
+	}
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to record repository revision: %s", err))
+	}
+}
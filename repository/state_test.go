@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	repoDir := writeTestRepo(t, testRepoFiles(t, unzippedManifestData))
+	defer os.RemoveAll(repoDir)
+	stateDir, err := ioutil.TempDir("", "marsho-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = repoDir + "/"
+	r.SkipGPGVerify = true
+	r.StateDir = stateDir
+
+	_, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := r.revisionState()
+	if err != nil || state.Revision != "" {
+		t.Error("expected unverified revision not to be recorded got", state, err)
+	}
+
+	// a newer revision was seen before, so the repository was rolled back
+	r.saveRevision(revisionState{"1500000000", time.Now()})
+	_, err = r.List()
+	if err == nil {
+		t.Error("expected rollback error for older revision")
+	}
+
+	r.Replay = true
+	_, err = r.List()
+	if err != nil {
+		t.Error("expected replay to accept older revision got", err)
+	}
+	state, _ = r.revisionState()
+	if state.Revision != "1500000000" {
+		t.Error("expected replay to keep revision 1500000000 got", state.Revision)
+	}
+
+	// the fixture metadata was generated in 2017
+	r.Replay = false
+	r.StateDir = ""
+	r.MaxMetadataAge = 30 * 24 * time.Hour
+	_, err = r.List()
+	if err == nil {
+		t.Error("expected error for metadata older than the maximum age")
+	}
+
+	r.MaxMetadataAge = time.Since(time.Unix(1487818901, 0)) + time.Hour
+	_, err = r.List()
+	if err != nil {
+		t.Error("expected metadata within the maximum age got", err)
+	}
+}
+
+func TestVerifiedRevision(t *testing.T) {
+	dir, entity, _ := signedBuildFixture(t)
+	defer os.RemoveAll(dir)
+	stateDir, err := ioutil.TempDir("", "marsho-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	r := DefaultRepository()
+	r.BaseUrl = dir + "/"
+	r.CacheDir = ""
+	r.StateDir = stateDir
+	r.Fingerprints = []string{(&gpgKey{entity}).fingerprint()}
+
+	for _, skipVerify := range []bool{true, false} {
+		r.SkipGPGVerify = skipVerify
+		metadata, err := r.metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := r.revisionState()
+		recorded := err == nil && state.Revision == metadata.Revision
+		if recorded == skipVerify {
+			t.Error("For skip verify", skipVerify, "expected recorded?", !skipVerify, "got", state, err)
+		}
+	}
+}
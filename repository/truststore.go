@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrustStore is the marsho owned keyring of repository signing keys the
+// user has chosen to trust, independent of any GnuPG installation. Each key
+// is only trusted for the repositories it was imported for, recorded by base
+// url in a json file next to the keyring.
+type TrustStore struct {
+	Path  string
+	keys  openpgp.EntityList
+	repos map[string][]string
+}
+
+// KeyInfo describes a public key for review before it is trusted, Expires
+// is the zero time for keys that never expire and Repositories lists the
+// base urls a trusted key is accepted for
+type KeyInfo struct {
+	Fingerprint  string
+	UIDs         []string
+	Created      time.Time
+	Expires      time.Time
+	Algorithm    string
+	Bits         int
+	Repositories []string
+}
+
+// DefaultTrustStorePath returns the per-user trust store, usually
+// ~/.config/marsho/trustedkeys.gpg
+func DefaultTrustStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "marsho", "trustedkeys.gpg")
+}
+
+// OpenTrustStore loads the trust store at path, a missing file is an empty
+// store
+func OpenTrustStore(path string) (*TrustStore, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	store := &TrustStore{Path: path, repos: map[string][]string{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	store.keys, _, err = readKeyRing(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading trust store %s: %s", path, err))
+	}
+
+	// keys without recorded repositories are not trusted for any
+	data, err = ioutil.ReadFile(store.reposPath())
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &store.repos)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading trust store %s: %s", store.reposPath(), err))
+	}
+	return store, nil
+}
+
+// reposPath is the file recording which repositories each key is trusted
+// for, trustedkeys.json next to trustedkeys.gpg
+func (s *TrustStore) reposPath() string {
+	return strings.TrimSuffix(s.Path, filepath.Ext(s.Path)) + ".json"
+}
+
+// ReadKeys parses every public key in an armored, binary or keybox keyring
+func ReadKeys(data []byte) ([]KeyInfo, error) {
+	keys, _, err := readKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	var infos []KeyInfo
+	for _, entity := range keys {
+		infos = append(infos, keyInfo(entity))
+	}
+	return infos, nil
+}
+
+// Keys returns the trusted keys ordered by fingerprint
+func (s *TrustStore) Keys() []KeyInfo {
+	var infos []KeyInfo
+	for _, entity := range s.keys {
+		infos = append(infos, s.keyInfo(entity))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Fingerprint < infos[j].Fingerprint
+	})
+	return infos
+}
+
+// Find returns the trusted key with fingerprint
+func (s *TrustStore) Find(fingerprint string) (KeyInfo, bool) {
+	entity := s.entity(normalizeFingerprint(fingerprint))
+	if entity == nil {
+		return KeyInfo{}, false
+	}
+	return s.keyInfo(entity), true
+}
+
+// Import trusts every key in data for the repository at baseUrl, replacing
+// keys that are already trusted and keeping the repositories they are
+// trusted for, call Save to persist the change
+func (s *TrustStore) Import(data []byte, baseUrl string) ([]KeyInfo, error) {
+	if baseUrl == "" {
+		return nil, errors.New("keys must be trusted for a repository")
+	}
+	keys, _, err := readKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	if s.repos == nil {
+		s.repos = map[string][]string{}
+	}
+
+	var imported []KeyInfo
+	for _, entity := range keys {
+		fingerprint := (&gpgKey{entity}).fingerprint()
+		repos := s.repos[fingerprint]
+		s.remove(fingerprint)
+		s.keys = append(s.keys, entity)
+		s.repos[fingerprint] = repos
+		if !s.trustedFor(fingerprint, baseUrl) {
+			s.repos[fingerprint] = append(repos, baseUrl)
+		}
+		imported = append(imported, s.keyInfo(entity))
+	}
+	return imported, nil
+}
+
+// Remove stops trusting the key with fingerprint, call Save to persist the
+// change
+func (s *TrustStore) Remove(fingerprint string) (KeyInfo, error) {
+	fingerprint = normalizeFingerprint(fingerprint)
+	entity := s.entity(fingerprint)
+	if entity == nil {
+		return KeyInfo{}, errors.New(fmt.Sprintf("key %s is not trusted", fingerprint))
+	}
+	info := s.keyInfo(entity)
+	s.remove(fingerprint)
+	return info, nil
+}
+
+// Save writes the store as a binary keyring readable by gpg --keyring
+func (s *TrustStore) Save() error {
+	var buf bytes.Buffer
+	for _, entity := range s.keys {
+		err := serializeEntity(&buf, entity)
+		if err != nil {
+			return err
+		}
+	}
+
+	repos, err := json.MarshalIndent(s.repos, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.Path, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.reposPath(), repos, 0600)
+}
+
+// serializeEntity writes the public parts of entity like Entity.Serialize
+// along with its key revocation signatures, which Entity.Serialize drops, so
+// a revoked key stays revoked once saved
+func serializeEntity(w io.Writer, entity *openpgp.Entity) error {
+	err := entity.PrimaryKey.Serialize(w)
+	if err != nil {
+		return err
+	}
+	for _, revocation := range entity.Revocations {
+		err = revocation.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range identityNames(entity) {
+		ident := entity.Identities[name]
+		err = ident.UserId.Serialize(w)
+		if err != nil {
+			return err
+		}
+		err = ident.SelfSignature.Serialize(w)
+		if err != nil {
+			return err
+		}
+		for _, sig := range ident.Signatures {
+			err = sig.Serialize(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		err = subkey.PublicKey.Serialize(w)
+		if err != nil {
+			return err
+		}
+		err = subkey.Sig.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trusts reports whether k is trusted for the repository at baseUrl
+func (s *TrustStore) trusts(k *gpgKey, baseUrl string) bool {
+	if s == nil || s.entity(k.fingerprint()) == nil {
+		return false
+	}
+	return s.trustedFor(k.fingerprint(), baseUrl)
+}
+
+func (s *TrustStore) trustedFor(fingerprint string, baseUrl string) bool {
+	for _, repo := range s.repos[fingerprint] {
+		if repo == baseUrl {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TrustStore) entity(fingerprint string) *openpgp.Entity {
+	for _, entity := range s.keys {
+		if (&gpgKey{entity}).fingerprint() == fingerprint {
+			return entity
+		}
+	}
+	return nil
+}
+
+func (s *TrustStore) remove(fingerprint string) {
+	keys := s.keys[:0]
+	for _, entity := range s.keys {
+		if (&gpgKey{entity}).fingerprint() != fingerprint {
+			keys = append(keys, entity)
+		}
+	}
+	s.keys = keys
+	delete(s.repos, fingerprint)
+}
+
+// keyInfo describes a trusted key along with its repositories
+func (s *TrustStore) keyInfo(entity *openpgp.Entity) KeyInfo {
+	info := keyInfo(entity)
+	info.Repositories = append([]string{}, s.repos[info.Fingerprint]...)
+	sort.Strings(info.Repositories)
+	return info
+}
+
+func keyInfo(entity *openpgp.Entity) KeyInfo {
+	key := &gpgKey{entity}
+	info := KeyInfo{
+		Fingerprint: key.fingerprint(),
+		Created:     entity.PrimaryKey.CreationTime,
+		Algorithm:   algorithmName(entity.PrimaryKey.PubKeyAlgo),
+	}
+	bits, err := entity.PrimaryKey.BitLength()
+	if err == nil {
+		info.Bits = int(bits)
+	}
+
+	info.UIDs = identityNames(entity)
+	info.Expires = keyExpiry(entity.PrimaryKey, primaryIdentity(entity))
+	return info
+}
+
+// identityNames returns the user ids of entity in a stable order
+func identityNames(entity *openpgp.Entity) []string {
+	var names []string
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func algorithmName(algo packet.PublicKeyAlgorithm) string {
+	switch algo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoRSAEncryptOnly:
+		return "RSA"
+	case packet.PubKeyAlgoDSA:
+		return "DSA"
+	case packet.PubKeyAlgoECDSA:
+		return "ECDSA"
+	case packet.PubKeyAlgoElGamal:
+		return "ElGamal"
+	case packet.PubKeyAlgoECDH:
+		return "ECDH"
+	}
+	return fmt.Sprintf("algorithm %d", algo)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustStore(t *testing.T) {
+	dir, entity, _ := signedBuildFixture(t)
+	defer os.RemoveAll(dir)
+	fingerprint := (&gpgKey{entity}).fingerprint()
+	storePath := filepath.Join(dir, "config", "trustedkeys.gpg")
+
+	store, err := OpenTrustStore(storePath)
+	if err != nil || len(store.Keys()) != 0 {
+		t.Fatal("expected empty trust store got", store, err)
+	}
+
+	// repositories are untrusted until their key is imported
+	r := DefaultRepository()
+	r.BaseUrl = dir + "/"
+	r.CacheDir = ""
+	r.KeyringPath = filepath.Join(dir, "missing-pubring.gpg")
+	r.TrustStore = store
+	_, err = r.List()
+	if err == nil {
+		t.Error("expected untrusted repository signing key error")
+	}
+
+	keyData, err := r.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := store.Import(keyData, r.BaseUrl)
+	if err != nil || len(imported) != 1 || imported[0].Fingerprint != fingerprint {
+		t.Fatal("expected imported signing key got", imported, err)
+	}
+	if imported[0].UIDs[0] != "marsho test <test@example.com>" || !imported[0].Expires.IsZero() ||
+		len(imported[0].Repositories) != 1 || imported[0].Repositories[0] != r.BaseUrl {
+		t.Error("unexpected key details", imported[0])
+	}
+	err = store.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenTrustStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Find("0x" + fingerprint); !ok {
+		t.Error("expected saved trust store to contain", fingerprint)
+	}
+	r.TrustStore = store
+	_, err = r.List()
+	if err != nil {
+		t.Error("expected trusted repository got", err)
+	}
+
+	// a key is only trusted for the repositories it was imported for, even
+	// when another repository serves the same key
+	other := r
+	other.BaseUrl = "file://" + dir + "/"
+	_, err = other.List()
+	if err == nil {
+		t.Error("expected key trusted for", r.BaseUrl, "to be refused for", other.BaseUrl)
+	}
+	other.Fingerprints = []string{"0123456789ABCDEF0123456789ABCDEF01234567"}
+	_, err = other.List()
+	if err == nil {
+		t.Error("expected the trust store not to widen pinned fingerprints")
+	}
+
+	// importing twice replaces the key rather than duplicating it and
+	// keeps the repositories it is trusted for
+	var binaryKey bytes.Buffer
+	entity.Serialize(&binaryKey)
+	store.Import(binaryKey.Bytes(), other.BaseUrl)
+	if len(store.Keys()) != 1 || len(store.Keys()[0].Repositories) != 2 {
+		t.Error("expected 1 trusted key for 2 repositories got", store.Keys())
+	}
+
+	_, err = store.Remove(fingerprint)
+	if err != nil || len(store.Keys()) != 0 {
+		t.Error("expected key to be removed got", err)
+	}
+	_, err = store.Remove(fingerprint)
+	if err == nil {
+		t.Error("expected error removing untrusted key")
+	}
+	_, err = store.Import([]byte("not a key"), r.BaseUrl)
+	if err == nil {
+		t.Error("expected error importing invalid key")
+	}
+	_, err = store.Import(keyData, "")
+	if err == nil {
+		t.Error("expected error importing a key for no repository")
+	}
+}
+
+func TestTrustStoreRevoked(t *testing.T) {
+	// revoked-key.asc was exported by gpg after importing its revocation
+	// certificate
+	keyData, err := ioutil.ReadFile(filepath.Join(fixtureDir, "revoked-key.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "marsho-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storePath := filepath.Join(dir, "trustedkeys.gpg")
+
+	store, _ := OpenTrustStore(storePath)
+	_, err = store.Import(keyData, "https://lime-mirror.example.internal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenTrustStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := store.entity("5B4AF82A7901611A9E84A35128AFC7969A6BD673")
+	if entity == nil || len(entity.Revocations) != 1 {
+		t.Error("expected the saved key to keep its revocation got", entity)
+	}
+}
+
+func TestTrustStoreUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marsho-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storePath := filepath.Join(dir, "trustedkeys.gpg")
+	ioutil.WriteFile(storePath, []byte("garbage"), 0600)
+	_, err = OpenTrustStore(storePath)
+	if err == nil {
+		t.Error("expected error for corrupt trust store")
+	}
+}
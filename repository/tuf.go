@@ -0,0 +1,796 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TUF metadata lives under tuf/ in the repository, root.json is pinned by
+// the user and later roots are published as <version>.root.json
+const (
+	tufDir       = "tuf/"
+	tufStateFile = "tuf.json"
+	tufRootFile  = "root.json"
+	tufMaxDepth  = 8
+)
+
+// tufSigned is the envelope every TUF role is published in, signatures are
+// over the canonical JSON encoding of signed
+type tufSigned struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []tufSignature  `json:"signatures"`
+}
+
+type tufSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type tufKey struct {
+	KeyType             string   `json:"keytype"`
+	Scheme              string   `json:"scheme"`
+	KeyIDHashAlgorithms []string `json:"keyid_hash_algorithms,omitempty"`
+	KeyVal              struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+	// raw is the key object as published, key ids are computed over it so
+	// fields marsho does not know about are still covered
+	raw json.RawMessage
+}
+
+func (k *tufKey) UnmarshalJSON(data []byte) error {
+	type plainKey tufKey
+	var key plainKey
+	err := json.Unmarshal(data, &key)
+	if err != nil {
+		return err
+	}
+	*k = tufKey(key)
+	k.raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+type tufRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+func (r tufRole) hasKey(keyID string) bool {
+	for _, id := range r.KeyIDs {
+		if id == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// sameKeys reports whether r and other trust the same set of key ids
+func (r tufRole) sameKeys(other tufRole) bool {
+	for _, id := range r.KeyIDs {
+		if !other.hasKey(id) {
+			return false
+		}
+	}
+	for _, id := range other.KeyIDs {
+		if !r.hasKey(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// tufHeader holds the fields common to every role
+type tufHeader struct {
+	Type    string    `json:"_type"`
+	Version int       `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type tufRoot struct {
+	tufHeader
+	Keys  map[string]tufKey  `json:"keys"`
+	Roles map[string]tufRole `json:"roles"`
+}
+
+// tufMeta describes another role file, Length and Hashes are optional
+type tufMeta struct {
+	Version int               `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// tufSnapshot is also used for timestamp.json, which lists snapshot.json
+type tufSnapshot struct {
+	tufHeader
+	Meta map[string]tufMeta `json:"meta"`
+}
+
+type tufTarget struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+type tufDelegation struct {
+	Name        string   `json:"name"`
+	KeyIDs      []string `json:"keyids"`
+	Threshold   int      `json:"threshold"`
+	Paths       []string `json:"paths"`
+	Terminating bool     `json:"terminating"`
+}
+
+type tufDelegations struct {
+	Keys  map[string]tufKey `json:"keys"`
+	Roles []tufDelegation   `json:"roles"`
+}
+
+type tufTargets struct {
+	tufHeader
+	Targets     map[string]tufTarget `json:"targets"`
+	Delegations *tufDelegations      `json:"delegations,omitempty"`
+}
+
+// tufVersions records the highest role versions accepted from a repository
+type tufVersions struct {
+	Timestamp int `json:"timestamp"`
+	Snapshot  int `json:"snapshot"`
+	Targets   int `json:"targets"`
+}
+
+// tufRepo is the verified TUF metadata of a repository, delegated roles are
+// loaded on demand while modules are downloaded
+type tufRepo struct {
+	repo     *Repository
+	now      time.Time
+	root     tufRoot
+	snapshot tufSnapshot
+	targets  tufTargets
+
+	mu        sync.Mutex
+	delegated map[string]tufTargets
+	// files holds every verified role file by repository path so a mirror
+	// can republish them
+	files map[string][]byte
+}
+
+// loadTUF verifies the repository's TUF metadata starting from the pinned
+// root in TUFRoot, following root rotations and checking thresholds,
+// expiry and rollback of every top level role
+func (r *Repository) loadTUF(now time.Time) (*tufRepo, error) {
+	if r.offline() {
+		return nil, errors.New("offline mode is not supported for TUF repositories")
+	}
+	t := &tufRepo{
+		repo:      r,
+		now:       now,
+		delegated: map[string]tufTargets{},
+		files:     map[string][]byte{},
+	}
+
+	err := t.updateRoot()
+	if err != nil {
+		return nil, err
+	}
+	versions := r.tufVersions()
+
+	timestampData, err := r.fetchBytes(tufDir + "timestamp.json")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error fetching TUF timestamp: %s", err))
+	}
+	var timestamp tufSnapshot
+	err = t.verifyTopRole(timestampData, "timestamp", &timestamp, &timestamp.tufHeader, versions.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotMeta, ok := timestamp.Meta["snapshot.json"]
+	if !ok {
+		return nil, errors.New("TUF timestamp does not list snapshot.json")
+	}
+	snapshotData, err := t.fetchRole("snapshot.json", snapshotMeta)
+	if err != nil {
+		return nil, err
+	}
+	err = t.verifyTopRole(snapshotData, "snapshot", &t.snapshot, &t.snapshot.tufHeader, versions.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if t.snapshot.Version != snapshotMeta.Version {
+		return nil, errors.New(fmt.Sprintf(
+			"TUF snapshot version %d does not match timestamp version %d",
+			t.snapshot.Version, snapshotMeta.Version,
+		))
+	}
+
+	targetsMeta, ok := t.snapshot.Meta["targets.json"]
+	if !ok {
+		return nil, errors.New("TUF snapshot does not list targets.json")
+	}
+	targetsData, err := t.fetchRole("targets.json", targetsMeta)
+	if err != nil {
+		return nil, err
+	}
+	err = t.verifyTopRole(targetsData, "targets", &t.targets, &t.targets.tufHeader, versions.Targets)
+	if err != nil {
+		return nil, err
+	}
+	if t.targets.Version != targetsMeta.Version {
+		return nil, errors.New(fmt.Sprintf(
+			"TUF targets version %d does not match snapshot version %d",
+			t.targets.Version, targetsMeta.Version,
+		))
+	}
+
+	if !r.Replay {
+		r.saveTUFVersions(tufVersions{timestamp.Version, t.snapshot.Version, t.targets.Version})
+	}
+	log.Debug(fmt.Sprintf(
+		"verified TUF metadata root %d timestamp %d snapshot %d targets %d",
+		t.root.Version, timestamp.Version, t.snapshot.Version, t.targets.Version,
+	))
+	return t, nil
+}
+
+// updateRoot loads the most recent trusted root and follows the chain of
+// published <version>.root.json files, each new root must be signed by a
+// threshold of both the previous and its own root keys
+func (t *tufRepo) updateRoot() error {
+	r := t.repo
+	rootData, err := r.trustedRoot()
+	if err != nil {
+		return err
+	}
+	// the trusted root is pinned as is, but must still be self consistent
+	var envelope tufSigned
+	err = json.Unmarshal(rootData, &envelope)
+	if err == nil {
+		err = json.Unmarshal(envelope.Signed, &t.root)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("error reading TUF root: %s", err))
+	}
+	err = verifyRole(rootData, "root", t.root.Keys, t.root.Roles["root"], &t.root)
+	if err != nil {
+		return err
+	}
+	rotated := false
+	keysRotated := false
+
+	for {
+		href := fmt.Sprintf("%s%d.root.json", tufDir, t.root.Version+1)
+		data, err := r.fetchBytes(href)
+		if notFound(err) {
+			break
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("error fetching %s: %s", href, err))
+		}
+
+		var next tufRoot
+		err = verifyRole(data, "root", t.root.Keys, t.root.Roles["root"], &next)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error verifying %s against previous root: %s", href, err))
+		}
+		err = verifyRole(data, "root", next.Keys, next.Roles["root"], &next)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error verifying %s against its own keys: %s", href, err))
+		}
+		if next.Version != t.root.Version+1 {
+			return errors.New(fmt.Sprintf("%s has version %d", href, next.Version))
+		}
+		log.Info(fmt.Sprintf("TUF root of %s rotated to version %d", r.Source(), next.Version))
+		for _, name := range []string{"timestamp", "snapshot"} {
+			if !t.root.Roles[name].sameKeys(next.Roles[name]) {
+				keysRotated = true
+			}
+		}
+		t.root = next
+		t.files[href] = data
+		rootData = data
+		rotated = true
+	}
+
+	if err := t.checkExpiry("root", t.root.tufHeader); err != nil {
+		return err
+	}
+	t.files[tufDir+tufRootFile] = rootData
+	if keysRotated {
+		// new timestamp or snapshot keys may restart their versions, the
+		// versions seen before are forgotten to recover from a fast forward
+		// attack with the old keys
+		log.Info(fmt.Sprintf("TUF timestamp or snapshot keys of %s rotated, resetting their versions", r.Source()))
+		versions := r.tufVersions()
+		versions.Timestamp = 0
+		versions.Snapshot = 0
+		r.saveTUFVersions(versions)
+	}
+	if rotated && r.StateDir != "" {
+		err = os.MkdirAll(filepath.Dir(r.statePath(tufRootFile)), 0700)
+		if err == nil {
+			err = writeFileAtomic(r.statePath(tufRootFile), rootData, 0600)
+		}
+		if err != nil {
+			log.Warning(fmt.Sprintf("unable to record rotated TUF root: %s", err))
+		}
+	}
+	return nil
+}
+
+// trustedRoot returns the newest root seen for the repository, falling back
+// to the pinned TUFRoot file
+func (r *Repository) trustedRoot() ([]byte, error) {
+	pinned, err := ioutil.ReadFile(r.TUFRoot)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading pinned TUF root: %s", err))
+	}
+	if r.StateDir == "" {
+		return pinned, nil
+	}
+	rotated, err := ioutil.ReadFile(r.statePath(tufRootFile))
+	if err != nil {
+		return pinned, nil
+	}
+	if rootVersion(rotated) > rootVersion(pinned) {
+		return rotated, nil
+	}
+	return pinned, nil
+}
+
+func rootVersion(data []byte) int {
+	var envelope tufSigned
+	var root tufRoot
+	if json.Unmarshal(data, &envelope) != nil || json.Unmarshal(envelope.Signed, &root) != nil {
+		return 0
+	}
+	return root.Version
+}
+
+// verifyTopRole checks a top level role against the root keys, its expiry
+// and that it is not older than the version last accepted
+func (t *tufRepo) verifyTopRole(data []byte, name string, v interface{}, header *tufHeader, seen int) error {
+	err := verifyRole(data, name, t.root.Keys, t.root.Roles[name], v)
+	if err != nil {
+		return err
+	}
+	if err := t.checkExpiry(name, *header); err != nil {
+		return err
+	}
+	if !t.repo.Replay && header.Version < seen {
+		return errors.New(fmt.Sprintf(
+			"TUF %s version %d is older than version %d seen before, "+
+				"the repository may have been rolled back, use -replay to accept it",
+			name, header.Version, seen,
+		))
+	}
+	t.files[tufDir+name+".json"] = data
+	return nil
+}
+
+func (t *tufRepo) checkExpiry(name string, header tufHeader) error {
+	if !t.repo.Replay && t.now.After(header.Expires) {
+		return errors.New(fmt.Sprintf(
+			"TUF %s metadata expired on %s, use -replay to accept it",
+			name, header.Expires.UTC().Format(time.RFC3339),
+		))
+	}
+	return nil
+}
+
+// fetchRole downloads a role file listed in timestamp or snapshot metadata
+// and checks its advertised length and hashes
+func (t *tufRepo) fetchRole(name string, meta tufMeta) ([]byte, error) {
+	data, err := t.repo.fetchBytes(tufDir + name)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error fetching TUF %s: %s", name, err))
+	}
+	if meta.Length != 0 || len(meta.Hashes) != 0 {
+		err = checkHashes(data, meta.Length, meta.Hashes)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("TUF %s %s", name, err))
+		}
+	}
+	return data, nil
+}
+
+// verifyTarget checks data against the targets metadata for href, which
+// may be delegated to other roles
+func (t *tufRepo) verifyTarget(href string, data []byte) error {
+	target, err := t.target(href)
+	if err != nil {
+		return err
+	}
+	err = checkHashes(data, target.Length, target.Hashes)
+	if err != nil {
+		return errors.New(fmt.Sprintf("TUF target %s %s", href, err))
+	}
+	log.Debug(fmt.Sprintf("verified TUF target %s", href))
+	return nil
+}
+
+func (t *tufRepo) target(href string) (tufTarget, error) {
+	target, found, err := t.findTarget(t.targets, href, 0)
+	if err != nil {
+		return tufTarget{}, err
+	}
+	if !found {
+		return tufTarget{}, errors.New(fmt.Sprintf("%s is not a trusted TUF target", href))
+	}
+	return target, nil
+}
+
+// findTarget searches targets and then its delegations in order, a
+// terminating delegation matching href ends the search
+func (t *tufRepo) findTarget(targets tufTargets, href string, depth int) (tufTarget, bool, error) {
+	if target, ok := targets.Targets[href]; ok {
+		return target, true, nil
+	}
+	if targets.Delegations == nil || depth >= tufMaxDepth {
+		return tufTarget{}, false, nil
+	}
+
+	for _, delegation := range targets.Delegations.Roles {
+		if !delegation.matches(href) {
+			continue
+		}
+		delegated, err := t.delegatedRole(delegation, targets.Delegations.Keys)
+		if err != nil {
+			return tufTarget{}, false, err
+		}
+		target, found, err := t.findTarget(delegated, href, depth+1)
+		if err != nil || found {
+			return target, found, err
+		}
+		if delegation.Terminating {
+			break
+		}
+	}
+	return tufTarget{}, false, nil
+}
+
+func (d tufDelegation) matches(href string) bool {
+	for _, pattern := range d.Paths {
+		if matched, _ := path.Match(pattern, href); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// delegatedRole loads and verifies a delegated targets role once
+func (t *tufRepo) delegatedRole(delegation tufDelegation, keys map[string]tufKey) (tufTargets, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if targets, ok := t.delegated[delegation.Name]; ok {
+		return targets, nil
+	}
+
+	name := delegation.Name
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return tufTargets{}, errors.New(fmt.Sprintf("invalid TUF delegated role name %q", name))
+	}
+	meta, ok := t.snapshot.Meta[name+".json"]
+	if !ok {
+		return tufTargets{}, errors.New(fmt.Sprintf("TUF snapshot does not list %s.json", name))
+	}
+	data, err := t.fetchRole(name+".json", meta)
+	if err != nil {
+		return tufTargets{}, err
+	}
+
+	var targets tufTargets
+	role := tufRole{delegation.KeyIDs, delegation.Threshold}
+	err = verifyRole(data, "targets", keys, role, &targets)
+	if err != nil {
+		return tufTargets{}, errors.New(fmt.Sprintf("TUF role %s: %s", name, err))
+	}
+	if targets.Version != meta.Version {
+		return tufTargets{}, errors.New(fmt.Sprintf(
+			"TUF role %s version %d does not match snapshot version %d",
+			name, targets.Version, meta.Version,
+		))
+	}
+	if err := t.checkExpiry(name, targets.tufHeader); err != nil {
+		return tufTargets{}, err
+	}
+
+	t.delegated[name] = targets
+	t.files[tufDir+name+".json"] = data
+	return targets, nil
+}
+
+// roleFiles returns the verified role files, timestamp.json last so a
+// mirror never publishes it before the files it refers to
+func (t *tufRepo) roleFiles() ([]string, map[string][]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	files := map[string][]byte{}
+	for name, data := range t.files {
+		files[name] = data
+		if name != tufDir+"timestamp.json" {
+			names = append(names, name)
+		}
+	}
+	names = append(names, tufDir+"timestamp.json")
+	return names, files
+}
+
+// verifyRole checks that data is a signed envelope of roleType carrying at
+// least threshold valid signatures from distinct keys of role, and decodes
+// the signed portion into v
+func verifyRole(data []byte, roleType string, keys map[string]tufKey, role tufRole, v interface{}) error {
+	var envelope tufSigned
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error reading TUF %s: %s", roleType, err))
+	}
+	if role.Threshold < 1 {
+		return errors.New(fmt.Sprintf("TUF %s role has no valid threshold", roleType))
+	}
+
+	signed, err := canonicalJSON(envelope.Signed)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error reading TUF %s: %s", roleType, err))
+	}
+
+	valid := map[string]bool{}
+	for _, sig := range envelope.Signatures {
+		if valid[sig.KeyID] || !role.hasKey(sig.KeyID) {
+			continue
+		}
+		key, ok := keys[sig.KeyID]
+		if !ok || !key.hasID(sig.KeyID) {
+			continue
+		}
+		if key.verify(signed, sig.Sig) {
+			valid[sig.KeyID] = true
+		}
+	}
+	if len(valid) < role.Threshold {
+		return errors.New(fmt.Sprintf(
+			"TUF %s has %d of %d required signatures", roleType, len(valid), role.Threshold,
+		))
+	}
+
+	var header tufHeader
+	err = json.Unmarshal(envelope.Signed, &header)
+	if err != nil || header.Type != roleType {
+		return errors.New(fmt.Sprintf("TUF metadata is %q not %s", header.Type, roleType))
+	}
+	return json.Unmarshal(envelope.Signed, v)
+}
+
+// id returns the key id, the hex sha256 of the key's canonical JSON
+func (k tufKey) id() string {
+	ids := k.ids()
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+// hasID reports whether keyID is one of the key's ids
+func (k tufKey) hasID(keyID string) bool {
+	for _, id := range k.ids() {
+		if id == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// ids returns the hex digests of the key's canonical JSON for each of its
+// keyid_hash_algorithms, sha256 when none are listed
+func (k tufKey) ids() []string {
+	data := []byte(k.raw)
+	if len(data) == 0 {
+		var err error
+		data, err = json.Marshal(k)
+		if err != nil {
+			return nil
+		}
+	}
+	canonical, err := canonicalJSON(data)
+	if err != nil {
+		return nil
+	}
+
+	algorithms := k.KeyIDHashAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha256"}
+	}
+	var ids []string
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case "sha256":
+			sum := sha256.Sum256(canonical)
+			ids = append(ids, hex.EncodeToString(sum[:]))
+		case "sha512":
+			sum := sha512.Sum512(canonical)
+			ids = append(ids, hex.EncodeToString(sum[:]))
+		}
+	}
+	return ids
+}
+
+func (k tufKey) verify(data []byte, sig string) bool {
+	if k.KeyType != "ed25519" || k.Scheme != "ed25519" {
+		return false
+	}
+	public, err := hex.DecodeString(k.KeyVal.Public)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return false
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(public), data, sigBytes)
+}
+
+// canonicalJSON re-encodes data in the canonical JSON form TUF signs, with
+// sorted keys, no insignificant whitespace, only quotes and backslashes
+// escaped in strings and integers as the only numbers, matching
+// securesystemslib's encode_canonical
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	var buf bytes.Buffer
+	err = encodeCanonical(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("canonical JSON only allows integers, found %s", v))
+		}
+		buf.WriteString(strconv.FormatInt(n, 10))
+	case string:
+		encodeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := encodeCanonical(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// byte order of UTF-8 is code point order, as python sorts
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			err := encodeCanonical(buf, v[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return errors.New(fmt.Sprintf("unsupported JSON value %v", v))
+	}
+	return nil
+}
+
+// encodeCanonicalString quotes s escaping only quotes and backslashes,
+// every other character including control characters is written as is
+func encodeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteByte('"')
+}
+
+// checkHashes verifies data against an advertised length and hashes, at
+// least one sha256 or sha512 hash is required
+func checkHashes(data []byte, length int64, hashes map[string]string) error {
+	if length != 0 && int64(len(data)) != length {
+		return errors.New(fmt.Sprintf("length mismatch expected: %d found: %d", length, len(data)))
+	}
+
+	checked := 0
+	for algorithm, expected := range hashes {
+		var sum []byte
+		switch algorithm {
+		case "sha256":
+			calc := sha256.Sum256(data)
+			sum = calc[:]
+		case "sha512":
+			calc := sha512.Sum512(data)
+			sum = calc[:]
+		default:
+			continue
+		}
+		expectedSum, err := hex.DecodeString(expected)
+		if err != nil || subtle.ConstantTimeCompare(sum, expectedSum) != 1 {
+			return errors.New(fmt.Sprintf(
+				"%s mismatch expected: %s found: %s", algorithm, expected, hex.EncodeToString(sum),
+			))
+		}
+		checked++
+	}
+	if checked == 0 {
+		return errors.New("has no sha256 or sha512 hash")
+	}
+	return nil
+}
+
+func (r *Repository) tufVersions() tufVersions {
+	var versions tufVersions
+	if r.StateDir == "" {
+		return versions
+	}
+	data, err := ioutil.ReadFile(r.statePath(tufStateFile))
+	if err != nil {
+		return versions
+	}
+	json.Unmarshal(data, &versions)
+	return versions
+}
+
+func (r *Repository) saveTUFVersions(versions tufVersions) {
+	if r.StateDir == "" {
+		return
+	}
+	data, err := json.Marshal(versions)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.statePath(tufStateFile)), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomic(r.statePath(tufStateFile), data, 0600)
+	}
+	if err != nil {
+		log.Warning(fmt.Sprintf("unable to record TUF versions: %s", err))
+	}
+}
@@ -0,0 +1,380 @@
+package repository
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tufTestKey struct {
+	id      string
+	key     tufKey
+	private ed25519.PrivateKey
+}
+
+func newTUFKey(t *testing.T) tufTestKey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := tufKey{KeyType: "ed25519", Scheme: "ed25519"}
+	key.KeyVal.Public = hex.EncodeToString(public)
+	return tufTestKey{key.id(), key, private}
+}
+
+// signRole wraps signed in a TUF envelope signed by keys
+func signRole(t *testing.T, signed interface{}, keys ...tufTestKey) []byte {
+	data, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := canonicalJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := tufSigned{Signed: data}
+	for _, key := range keys {
+		sig := ed25519.Sign(key.private, canonical)
+		envelope.Signatures = append(envelope.Signatures, tufSignature{key.id, hex.EncodeToString(sig)})
+	}
+	data, err = json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func tufHash(data []byte) map[string]string {
+	sum := sha256.Sum256(data)
+	return map[string]string{"sha256": hex.EncodeToString(sum[:])}
+}
+
+// tufFixture is a built repository with TUF metadata, the modules/ targets
+// are delegated to a separate role
+type tufFixture struct {
+	dir                                          string
+	root, timestamp, snapshot, targets, delegate tufTestKey
+	rootRole                                     tufRoot
+	targetsRole, delegated                       tufTargets
+	expires                                      time.Time
+	timestampVersion, snapshotVersion            int
+}
+
+func newTUFFixture(t *testing.T) *tufFixture {
+	dir := buildFixture(t)
+	_, err := Build(dir, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &tufFixture{
+		dir:       dir,
+		root:      newTUFKey(t),
+		timestamp: newTUFKey(t),
+		snapshot:  newTUFKey(t),
+		targets:   newTUFKey(t),
+		delegate:  newTUFKey(t),
+		expires:   time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second),
+
+		timestampVersion: 1,
+		snapshotVersion:  1,
+	}
+
+	f.rootRole = tufRoot{
+		tufHeader: tufHeader{"root", 1, f.expires},
+		Keys:      map[string]tufKey{},
+		Roles:     map[string]tufRole{},
+	}
+	for name, key := range map[string]tufTestKey{
+		"root": f.root, "timestamp": f.timestamp, "snapshot": f.snapshot, "targets": f.targets,
+	} {
+		f.rootRole.Keys[key.id] = key.key
+		f.rootRole.Roles[name] = tufRole{[]string{key.id}, 1}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "root.json"), signRole(t, f.rootRole, f.root), 0644)
+
+	repomd, _ := ioutil.ReadFile(filepath.Join(dir, "repodata", "repomd.xml"))
+	module, _ := ioutil.ReadFile(filepath.Join(dir, "modules", fixtureModule))
+	f.targetsRole = tufTargets{
+		tufHeader: tufHeader{"targets", 1, f.expires},
+		Targets: map[string]tufTarget{
+			"repodata/repomd.xml": {int64(len(repomd)), tufHash(repomd)},
+		},
+		Delegations: &tufDelegations{
+			Keys: map[string]tufKey{f.delegate.id: f.delegate.key},
+			Roles: []tufDelegation{
+				{"modules", []string{f.delegate.id}, 1, []string{"modules/*"}, true},
+			},
+		},
+	}
+	f.delegated = tufTargets{
+		tufHeader: tufHeader{"targets", 1, f.expires},
+		Targets: map[string]tufTarget{
+			"modules/" + fixtureModule: {int64(len(module)), tufHash(module)},
+		},
+	}
+	f.publish(t)
+	return f
+}
+
+// publish signs and writes targets, the delegated role, snapshot and
+// timestamp
+func (f *tufFixture) publish(t *testing.T) {
+	files := map[string][]byte{
+		"targets.json": signRole(t, f.targetsRole, f.targets),
+		"modules.json": signRole(t, f.delegated, f.delegate),
+	}
+	snapshot := tufSnapshot{
+		tufHeader: tufHeader{"snapshot", f.snapshotVersion, f.expires},
+		Meta: map[string]tufMeta{
+			"targets.json": {Version: f.targetsRole.Version},
+			"modules.json": {Version: f.delegated.Version},
+		},
+	}
+	files["snapshot.json"] = signRole(t, snapshot, f.snapshot)
+	timestamp := tufSnapshot{
+		tufHeader: tufHeader{"timestamp", f.timestampVersion, f.expires},
+		Meta: map[string]tufMeta{
+			"snapshot.json": {
+				Version: f.snapshotVersion,
+				Length:  int64(len(files["snapshot.json"])),
+				Hashes:  tufHash(files["snapshot.json"]),
+			},
+		},
+	}
+	files["timestamp.json"] = signRole(t, timestamp, f.timestamp)
+
+	os.MkdirAll(filepath.Join(f.dir, "tuf"), 0755)
+	for name, data := range files {
+		ioutil.WriteFile(filepath.Join(f.dir, "tuf", name), data, 0644)
+	}
+}
+
+func (f *tufFixture) repository(stateDir string) Repository {
+	r := DefaultRepository()
+	r.BaseUrl = f.dir + "/"
+	r.CacheDir = ""
+	r.StateDir = stateDir
+	r.TUFRoot = filepath.Join(f.dir, "root.json")
+	return r
+}
+
+// fetch lists the repository and downloads its module
+func (f *tufFixture) fetch(r Repository) error {
+	manifest, err := r.List()
+	if err != nil {
+		return err
+	}
+	_, err = r.downloadTo(manifest.Modules[0], filepath.Join(f.dir, "download.ko"))
+	return err
+}
+
+func TestTUF(t *testing.T) {
+	f := newTUFFixture(t)
+	defer os.RemoveAll(f.dir)
+	stateDir, err := ioutil.TempDir("", "marsho-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	err = f.fetch(f.repository(stateDir))
+	if err != nil {
+		t.Fatal("expected TUF repository to verify got", err)
+	}
+
+	// the root key is rotated, the new root is signed by old and new keys
+	newRoot := newTUFKey(t)
+	f.rootRole.Version = 2
+	f.rootRole.Keys[newRoot.id] = newRoot.key
+	f.rootRole.Roles["root"] = tufRole{[]string{newRoot.id}, 1}
+	ioutil.WriteFile(filepath.Join(f.dir, "tuf", "2.root.json"), signRole(t, f.rootRole, f.root, newRoot), 0644)
+	err = f.fetch(f.repository(stateDir))
+	if err != nil {
+		t.Error("expected rotated root to verify got", err)
+	}
+	r := f.repository(stateDir)
+	rotated, _ := ioutil.ReadFile(r.statePath(tufRootFile))
+	if rootVersion(rotated) != 2 {
+		t.Error("expected rotated root version 2 to be recorded got", rootVersion(rotated))
+	}
+
+	// a root not signed by the previous root keys is refused
+	f.rootRole.Version = 3
+	ioutil.WriteFile(filepath.Join(f.dir, "tuf", "3.root.json"), signRole(t, f.rootRole, f.root), 0644)
+	err = f.fetch(f.repository(stateDir))
+	if err == nil {
+		t.Error("expected error for root signed by rotated out key")
+	}
+	os.Remove(filepath.Join(f.dir, "tuf", "3.root.json"))
+
+	// timestamp, snapshot and targets must not roll back
+	f.timestampVersion = 0
+	f.publish(t)
+	err = f.fetch(f.repository(stateDir))
+	if err == nil {
+		t.Error("expected rollback error for older timestamp")
+	}
+	r = f.repository(stateDir)
+	r.Replay = true
+	err = f.fetch(r)
+	if err != nil {
+		t.Error("expected replay to accept older timestamp got", err)
+	}
+}
+
+func TestTUFRejects(t *testing.T) {
+	var rejecttests = []struct {
+		name   string
+		change func(t *testing.T, f *tufFixture)
+	}{
+		{"expired targets", func(t *testing.T, f *tufFixture) {
+			f.targetsRole.Expires = time.Now().Add(-time.Hour)
+		}},
+		{"unknown targets key", func(t *testing.T, f *tufFixture) {
+			f.targets = newTUFKey(t)
+		}},
+		{"threshold not met", func(t *testing.T, f *tufFixture) {
+			role := f.rootRole.Roles["snapshot"]
+			role.Threshold = 2
+			f.rootRole.Roles["snapshot"] = role
+			ioutil.WriteFile(filepath.Join(f.dir, "root.json"), signRole(t, f.rootRole, f.root), 0644)
+		}},
+		{"tampered module target", func(t *testing.T, f *tufFixture) {
+			f.delegated.Targets["modules/"+fixtureModule] = tufTarget{568, tufHash([]byte("other"))}
+		}},
+		{"undelegated module", func(t *testing.T, f *tufFixture) {
+			f.targetsRole.Delegations.Roles[0].Paths = []string{"other/*"}
+		}},
+		{"unsigned repomd", func(t *testing.T, f *tufFixture) {
+			delete(f.targetsRole.Targets, "repodata/repomd.xml")
+		}},
+	}
+
+	for _, test := range rejecttests {
+		f := newTUFFixture(t)
+		test.change(t, f)
+		f.publish(t)
+		err := f.fetch(f.repository(""))
+		if err == nil {
+			t.Error("For", test.name, "expected TUF verification error")
+		}
+		os.RemoveAll(f.dir)
+	}
+}
+
+func TestTUFKeyRotation(t *testing.T) {
+	f := newTUFFixture(t)
+	defer os.RemoveAll(f.dir)
+	stateDir, err := ioutil.TempDir("", "marsho-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	f.timestampVersion = 5
+	f.snapshotVersion = 5
+	f.publish(t)
+	err = f.fetch(f.repository(stateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new timestamp and snapshot keys restart their versions, which is only
+	// accepted because the new root rotated their keys
+	f.timestamp = newTUFKey(t)
+	f.snapshot = newTUFKey(t)
+	f.rootRole.Version = 2
+	for name, key := range map[string]tufTestKey{"timestamp": f.timestamp, "snapshot": f.snapshot} {
+		f.rootRole.Keys[key.id] = key.key
+		f.rootRole.Roles[name] = tufRole{[]string{key.id}, 1}
+	}
+	ioutil.WriteFile(filepath.Join(f.dir, "tuf", "2.root.json"), signRole(t, f.rootRole, f.root), 0644)
+	f.timestampVersion = 1
+	f.snapshotVersion = 1
+	f.publish(t)
+	err = f.fetch(f.repository(stateDir))
+	if err != nil {
+		t.Error("expected versions to restart after a key rotation got", err)
+	}
+
+	// a root rotation keeping those keys keeps the versions
+	f.rootRole.Version = 3
+	ioutil.WriteFile(filepath.Join(f.dir, "tuf", "3.root.json"), signRole(t, f.rootRole, f.root), 0644)
+	f.timestampVersion = 0
+	f.publish(t)
+	err = f.fetch(f.repository(stateDir))
+	if err == nil {
+		t.Error("expected rollback error after a root rotation keeping the timestamp key")
+	}
+}
+
+func TestTUFKeyID(t *testing.T) {
+	// key ids cover the key object as published, including fields marsho
+	// does not use and keyid_hash_algorithms
+	published := `{"keytype": "ed25519", "scheme": "ed25519", ` +
+		`"keyid_hash_algorithms": ["sha256", "sha512"], ` +
+		`"keyval": {"public": "7a6d7c8b5e3f1d2c4b6a8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d"}}`
+	canonical := `{"keyid_hash_algorithms":["sha256","sha512"],"keytype":"ed25519",` +
+		`"keyval":{"public":"7a6d7c8b5e3f1d2c4b6a8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d"},"scheme":"ed25519"}`
+	sha256Sum := sha256.Sum256([]byte(canonical))
+	sha512Sum := sha512.Sum512([]byte(canonical))
+
+	var key tufKey
+	err := json.Unmarshal([]byte(published), &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{hex.EncodeToString(sha256Sum[:]), hex.EncodeToString(sha512Sum[:])} {
+		if !key.hasID(id) {
+			t.Error("expected key id", id, "got", key.ids())
+		}
+	}
+
+	var unknown tufKey
+	json.Unmarshal([]byte(`{"keytype":"ed25519","scheme":"ed25519","keyval":{"public":"00","private":""}}`), &unknown)
+	plain := unknown
+	plain.raw = nil
+	if unknown.id() == plain.id() {
+		t.Error("expected unknown key fields to be part of the key id")
+	}
+}
+
+var canonicaltests = []struct {
+	input    string
+	expected string
+	valid    bool
+}{
+	{`{ "b": [1, 2], "a": "<x>&", "c": {"z": true, "y": null} }`, `{"a":"<x>&","b":[1,2],"c":{"y":null,"z":true}}`, true},
+	{`{"s": "quote \" backslash \\ slash \/"}`, `{"s":"quote \" backslash \\ slash /"}`, true},
+	{`"line\nfeed\ttab\u0001"`, "\"line\nfeed\ttab\u0001\"", true},
+	{`"\u00e9 \u2028"`, "\"\u00e9 \u2028\"", true},
+	{`{"\u00e9": 1, "z": 2, "Z": 3}`, "{\"Z\":3,\"z\":2,\"\u00e9\":1}", true},
+	{`[-0, 10, -12]`, `[0,10,-12]`, true},
+	{`{"version": 1.5}`, ``, false},
+	{`{"version": 1e3}`, ``, false},
+	{`{"a": 1} {"b": 2}`, ``, false},
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, test := range canonicaltests {
+		canonical, err := canonicalJSON([]byte(test.input))
+		if !test.valid {
+			if err == nil {
+				t.Error("For", test.input, "expected error got", string(canonical))
+			}
+			continue
+		}
+		if err != nil || string(canonical) != test.expected {
+			t.Error("For", test.input, "expected", test.expected, "got", string(canonical), err)
+		}
+	}
+}
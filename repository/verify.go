@@ -1,17 +1,21 @@
 package repository
 
 import (
-	"crypto/sha256"
+	"bufio"
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type gpgKeyring struct {
@@ -24,45 +28,96 @@ type gpgKey struct {
 	key *openpgp.Entity
 }
 
-func getDefaultKeyring() (*gpgKeyring, error) {
-	usr, err := user.Current()
-	if err != nil {
-		log.Fatal(err)
-	}
+// GnuPGKeyring is the keyring setting naming the user's GnuPG keyring
+const GnuPGKeyring = "gnupg"
 
-	path := filepath.Join(usr.HomeDir, ".gnupg", "pubring.gpg")
-
-	var keyring openpgp.EntityList
-	// check if the default gpg keyring exists
-	if _, err := os.Stat(path); err == nil {
-		keyringFile, err := os.Open(path)
+// getDefaultKeyring loads the public keyring at path, or the GnuPG keyring
+// in $GNUPGHOME (~/.gnupg by default) when path is GnuPGKeyring, preferring
+// the pubring.kbx keybox written by GnuPG 2.1+ over the legacy pubring.gpg
+func getDefaultKeyring(path string) (*gpgKeyring, error) {
+	if path == GnuPGKeyring {
+		home, err := gnupgHome()
 		if err != nil {
 			return nil, err
 		}
-		defer keyringFile.Close()
-
-		keyring, err = openpgp.ReadKeyRing(keyringFile)
-		if err != nil {
-			return nil, err
+		path = filepath.Join(home, "pubring.gpg")
+		kbxPath := filepath.Join(home, "pubring.kbx")
+		if _, err := os.Stat(kbxPath); err == nil {
+			path = kbxPath
 		}
-	} else {
+	}
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
 
+	keyring, version, err := readKeyRing(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	log.Debug(fmt.Sprintf("loaded %d keys from %s keyring %s", len(keyring), version, path))
+
 	return &gpgKeyring{
-		"gpg",
+		version,
 		path,
 		&keyring,
 	}, nil
 }
 
-//TODO: handle armored and unarmored keys
+// readKeyRing parses a keybox, armored or binary keyring and reports which
+// format it was in
+func readKeyRing(data []byte) (openpgp.EntityList, string, error) {
+	var keyring openpgp.EntityList
+	var err error
+	switch {
+	case isKeybox(data):
+		keyring, err = readKeybox(data)
+		return keyring, "kbx", err
+	case bytes.HasPrefix(bytes.TrimSpace(data), armorHeader):
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		return keyring, "armored", err
+	default:
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		return keyring, "gpg", err
+	}
+}
+
+// gnupgHome returns $GNUPGHOME or ~/.gnupg
+func gnupgHome() (string, error) {
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		return expandHome(home)
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".gnupg"), nil
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, path[2:]), nil
+}
+
+// readKey reads the first public key from an armored or binary key
 func readKey(reader io.Reader) (*gpgKey, error) {
-	block, err := armor.Decode(reader)
+	body, err := unarmor(reader)
 	if err != nil {
 		return nil, err
 	}
-	preader := packet.NewReader(block.Body)
+	preader := packet.NewReader(body)
 	keyEntity, err := openpgp.ReadEntity(preader)
 	if err != nil {
 		return nil, err
@@ -72,10 +127,37 @@ func readKey(reader io.Reader) (*gpgKey, error) {
 	}, err
 }
 
+// armorHeader starts every ASCII armored OpenPGP block
+var armorHeader = []byte("-----BEGIN PGP")
+
+// unarmor returns the binary OpenPGP packets in reader, decoding ASCII armor
+// when present and passing binary data through unchanged
+func unarmor(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	// armor may be preceded by blank lines, peek far enough to skip them
+	peek, _ := buffered.Peek(512)
+	if !bytes.HasPrefix(bytes.TrimLeft(peek, " \t\r\n"), armorHeader) {
+		return buffered, nil
+	}
+	block, err := armor.Decode(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return block.Body, nil
+}
+
 func (k *gpgKey) fingerprint() string {
 	return strings.ToUpper(hex.EncodeToString(k.key.PrimaryKey.Fingerprint[:20]))
 }
 
+// normalizeFingerprint formats a user supplied fingerprint the way
+// gpgKey.fingerprint does, ignoring spaces and a leading 0x
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Replace(fingerprint, " ", "", -1)
+	fingerprint = strings.TrimPrefix(strings.TrimPrefix(fingerprint, "0x"), "0X")
+	return strings.ToUpper(fingerprint)
+}
+
 func (kr *gpgKeyring) contains(k *gpgKey) bool {
 	for _, entity := range *kr.keys {
 		if entity.PrimaryKey.Fingerprint == k.key.PrimaryKey.Fingerprint {
@@ -90,19 +172,42 @@ func (kr *gpgKeyring) contains(k *gpgKey) bool {
 	return false
 }
 
-func (kr *gpgKeyring) verifyDetachedSig(data io.Reader, sig io.Reader) (*gpgKey, error) {
-	signer, err := openpgp.CheckDetachedSignature(*kr.keys, data, sig)
-	return &gpgKey{
-		signer,
-	}, err
-}
+// verifyDetachedSig checks an armored or binary detached signature of data
+// and that the signing key and algorithms satisfy policy
+func (kr *gpgKeyring) verifyDetachedSig(data io.Reader, sig io.Reader, policy Policy) (*gpgKey, error) {
+	sigBody, err := unarmor(sig)
+	if err != nil {
+		return nil, err
+	}
+	sigData, err := ioutil.ReadAll(sigBody)
+	if err != nil {
+		return nil, err
+	}
+
+	sigInfo, issuerKeys, err := readSignatureInfo(*kr.keys, sigData)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range issuerKeys {
+		err = checkRevoked(key)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func sha256sum(data []byte, checksum string) (bool, string) {
-	calcSum := sha256.Sum256(data)
-	calcSumString := hex.EncodeToString(calcSum[:32])
-	if calcSumString == checksum {
-		return true, calcSumString
-	} else {
-		return false, calcSumString
+	signer, err := openpgp.CheckDetachedSignature(*kr.keys, data, bytes.NewReader(sigData))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range issuerKeys {
+		if key.Entity == signer {
+			err = policy.check(key, sigInfo, time.Now())
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
+	return &gpgKey{
+		signer,
+	}, nil
 }
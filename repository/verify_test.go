@@ -1,37 +1,109 @@
 package repository
 
-import "testing"
+import (
+	"bytes"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"testing"
+)
 
 type checksuminput struct {
 	data     []byte
-	checksum string
+	checksum Checksum
 	valid    bool
+	err      bool
 }
 
 var checksumtests = []checksuminput{
 	{
 		[]byte("passing checksum test value"),
-		"a4fe0d179401df2c292d6a013f9d30521f486185923981e5accaaa20e4a44b7e",
-		true,
+		Checksum{"", "a4fe0d179401df2c292d6a013f9d30521f486185923981e5accaaa20e4a44b7e"},
+		true, false,
 	},
 	{
 		[]byte("failing checksum test value"),
-		"1886e7b285e9d1a29f2a208dce7d7586aefec980b07cb68026f689bea4b52133",
-		false,
+		Checksum{"sha256", "1886e7b285e9d1a29f2a208dce7d7586aefec980b07cb68026f689bea4b52133"},
+		false, false,
+	},
+	{
+		[]byte("passing checksum test value"),
+		Checksum{"sha384", "7b3bb14a789e6d8f98830ab7f7b9eb3ce1c36ccd2697d5265c2197b5b227aed57807c04b7890916e101f0665ddc3d1b9"},
+		true, false,
+	},
+	{
+		[]byte("passing checksum test value"),
+		Checksum{"SHA512", "96F4D1D76C1C5B91679A51ADEBF35F9A06902930DA3BEF543DA87FB9E0F3E6FFE91A126B6160A728132017851A810090CBF90ABEC488C327A22E64001181B991"},
+		true, false,
+	},
+	{
+		[]byte("passing checksum test value"),
+		Checksum{"sha", "44d763e3754164cd9e653b219a64db2c8921f425"},
+		true, false,
+	},
+	{
+		[]byte("passing checksum test value"),
+		Checksum{"sha256", "44d763e3754164cd9e653b219a64db2c8921f425"},
+		false, false,
+	},
+	{
+		[]byte("passing checksum test value"),
+		Checksum{"md5", "5d6c1e4a2e6d5f1c3d4b8c1f1e0f8c2a"},
+		false, true,
 	},
 }
 
-func TestSha256sum(t *testing.T) {
+func TestChecksum(t *testing.T) {
 	for _, input := range checksumtests {
-		valid, calcSum := sha256sum(input.data, input.checksum)
-		if valid != input.valid {
+		valid, calcSum, err := input.checksum.verify(input.data)
+		if valid != input.valid || (err != nil) != input.err {
 			t.Error(
 				"For data:", string(input.data), "(cast from []byte),",
-				"and checksum", input.checksum,
-				"expected valid?", input.valid,
+				"and checksum", input.checksum.Type, input.checksum.Value,
+				"expected valid?", input.valid, "error?", input.err,
 				"got valid?", valid,
-				"with checksum", calcSum,
+				"with checksum", calcSum, "error", err,
 			)
 		}
 	}
 }
+
+func TestArmorDetection(t *testing.T) {
+	entity, err := openpgp.NewEntity("marsho test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("signed repository metadata")
+
+	var binaryKey, armoredKey, binarySig, armoredSig bytes.Buffer
+	entity.Serialize(&binaryKey)
+	armorWriter, _ := armor.Encode(&armoredKey, openpgp.PublicKeyType, nil)
+	entity.Serialize(armorWriter)
+	armorWriter.Close()
+	openpgp.DetachSign(&binarySig, entity, bytes.NewReader(data), nil)
+	openpgp.ArmoredDetachSign(&armoredSig, entity, bytes.NewReader(data), nil)
+
+	for name, keyData := range map[string][]byte{
+		"binary key":  binaryKey.Bytes(),
+		"armored key": append([]byte("\n\n"), armoredKey.Bytes()...),
+	} {
+		key, err := readKey(bytes.NewReader(keyData))
+		if err != nil || key.key.PrimaryKey.Fingerprint != entity.PrimaryKey.Fingerprint {
+			t.Error("For", name, "expected test key got", err)
+		}
+	}
+
+	keyring := &gpgKeyring{"gpg", "", &openpgp.EntityList{entity}}
+	for name, sig := range map[string][]byte{
+		"binary signature":  binarySig.Bytes(),
+		"armored signature": armoredSig.Bytes(),
+	} {
+		_, err := keyring.verifyDetachedSig(bytes.NewReader(data), bytes.NewReader(sig), Policy{})
+		if err != nil {
+			t.Error("For", name, "expected valid signature got", err)
+		}
+		_, err = keyring.verifyDetachedSig(bytes.NewReader([]byte("tampered")), bytes.NewReader(sig), Policy{})
+		if err == nil {
+			t.Error("For", name, "expected invalid signature for tampered data")
+		}
+	}
+}